@@ -0,0 +1,244 @@
+package main
+
+import (
+    "errors"
+    "sort"
+    "strconv"
+    "strings"
+
+    "github.com/gin-gonic/gin"
+)
+
+// CandidateInfo 候选人的得票、佣金和状态
+type CandidateInfo struct {
+    CandidateTally
+    Commission int
+    Jailed     bool
+    Slashed    bool
+}
+
+// ProposalInfo 参数提案及其获得的赞成权重和全部投票权重
+type ProposalInfo struct {
+    Proposal
+    Approved int
+    Total    int
+}
+
+// AccountInfo 账户的余额、质押和投票分配
+type AccountInfo struct {
+    Address   string
+    Balance   int
+    Staked    int
+    Unbonding []Unbonding
+    Approvals []string
+    Proxy     string
+    Nonce     int64
+}
+
+// RunAPI 在 addr 上提供节点的 HTTP 接口
+func (n *NetworkNode) RunAPI(addr string) error {
+    r := gin.Default()
+    r.POST("/submitTransaction", submitTransaction(n))
+    r.GET("/transfer", nodeTransaction(n, TxTransfer))
+    r.GET("/vote", nodeTransaction(n, TxVote))
+    r.GET("/unvote", nodeTransaction(n, TxUnvote))
+    r.GET("/proxy", nodeTransaction(n, TxProxy))
+    r.GET("/propose", nodeTransaction(n, TxPropose))
+    r.GET("/approve", nodeTransaction(n, TxApprove))
+    r.GET("/getCandidates", getCandidates(n))
+    r.GET("/getDelegates", getDelegates(n))
+    r.GET("/getBlock", getBlock(n))
+    r.GET("/getAccount", getAccount(n))
+    r.GET("/getProposals", getProposals(n))
+    return r.Run(addr)
+}
+
+// submitTransaction 提交客户端已签名的交易
+func submitTransaction(n *NetworkNode) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        var tx Transaction
+        if err := c.ShouldBindJSON(&tx); err != nil {
+            c.JSON(400, gin.H{
+                "message": err.Error(),
+            })
+            return
+        }
+        if err := n.handleTransaction(tx, true); errors.Is(err, errKnownTransaction) {
+            c.JSON(409, gin.H{
+                "message": err.Error(),
+            })
+            return
+        } else if err != nil {
+            c.JSON(400, gin.H{
+                "message": err.Error(),
+            })
+            return
+        }
+        c.JSON(200, gin.H{
+            "message": "transaction submitted",
+        })
+    }
+}
+
+// nodeTransaction 由本节点的账户签名并提交交易，参数为 to、amount 和逗号分隔的 candidates；
+// 参数提案另带 param 和 epoch，提议的取值和赞成的提案编号都放在 amount 中
+func nodeTransaction(n *NetworkNode, txType string) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        amount, err := strconv.Atoi(c.DefaultQuery("amount", "0"))
+        if err != nil {
+            c.JSON(400, gin.H{
+                "message": "invalid amount",
+            })
+            return
+        }
+        tx := Transaction{Type: txType, To: c.Query("to"), Amount: amount}
+        if candidates := c.Query("candidates"); candidates != "" {
+            tx.Candidates = strings.Split(candidates, ",")
+        }
+        if param := c.Query("param"); param != "" {
+            epoch, err := strconv.Atoi(c.Query("epoch"))
+            if err != nil {
+                c.JSON(400, gin.H{
+                    "message": "invalid epoch",
+                })
+                return
+            }
+            tx.Proposal = &ParamChange{Param: param, Value: amount, Epoch: epoch}
+        }
+        n.lock.Lock()
+        tx.From = n.self.Address
+        tx.Nonce = n.bc.nextNonce(n.self.Address)
+        signTransaction(&tx, n.self.privateKey)
+        n.lock.Unlock()
+        if err := n.handleTransaction(tx, true); err != nil {
+            c.JSON(400, gin.H{
+                "message": err.Error(),
+            })
+            return
+        }
+        c.JSON(200, gin.H{
+            "message":     "transaction submitted",
+            "transaction": tx,
+        })
+    }
+}
+
+// getCandidates 全部候选人按得票从高到低排列，包括被监禁和罚没的候选人
+func getCandidates(n *NetworkNode) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        n.lock.Lock()
+        defer n.lock.Unlock()
+        state := n.bc.State
+        candidates := make([]string, 0, len(state.Candidates))
+        for candidate := range state.Candidates {
+            candidates = append(candidates, candidate)
+        }
+        sort.Strings(candidates)
+        var infos []CandidateInfo
+        for _, tally := range state.tally(candidates) {
+            infos = append(infos, CandidateInfo{
+                CandidateTally: tally,
+                Commission:     state.commissionOf(tally.Candidate),
+                Jailed:         state.Jailed[tally.Candidate],
+                Slashed:        state.Slashed[tally.Candidate],
+            })
+        }
+        c.JSON(200, gin.H{
+            "candidates": infos,
+        })
+    }
+}
+
+// getDelegates 本届受托人及其得票、当前轮次的出块顺序和不可逆区块
+func getDelegates(n *NetworkNode) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        n.lock.Lock()
+        defer n.lock.Unlock()
+        state, s := n.bc.State, n.scheduler
+        received := make(map[string]CandidateTally)
+        for _, tally := range state.tally(s.Delegates) {
+            received[tally.Candidate] = tally
+        }
+        delegates := make([]CandidateTally, 0, len(s.Delegates))
+        for _, delegate := range s.Delegates {
+            delegates = append(delegates, received[delegate])
+        }
+        c.JSON(200, gin.H{
+            "epoch":            s.Epoch,
+            "round":            s.Round,
+            "delegates":        delegates,
+            "order":            s.Order,
+            "lastIrreversible": n.bc.LastIrreversible,
+        })
+    }
+}
+
+// getBlock 按 index 或 hash 查询区块
+func getBlock(n *NetworkNode) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        n.lock.Lock()
+        defer n.lock.Unlock()
+        if hash := c.Query("hash"); hash != "" {
+            for _, block := range n.bc.Blocks {
+                if block.Hash == hash {
+                    c.JSON(200, block)
+                    return
+                }
+            }
+            c.JSON(404, gin.H{
+                "message": "block not found",
+            })
+            return
+        }
+        index, err := strconv.Atoi(c.Query("index"))
+        if err != nil || index < 0 || index >= len(n.bc.Blocks) {
+            c.JSON(404, gin.H{
+                "message": "block not found",
+            })
+            return
+        }
+        c.JSON(200, n.bc.Blocks[index])
+    }
+}
+
+// getAccount 查询账户的余额和投票分配，不带 address 时查询本节点账户
+func getAccount(n *NetworkNode) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        n.lock.Lock()
+        defer n.lock.Unlock()
+        address := c.DefaultQuery("address", n.self.Address)
+        state := n.bc.State
+        info := AccountInfo{
+            Address:   address,
+            Balance:   state.Balances[address],
+            Staked:    state.Staked[address],
+            Approvals: state.Approvals[address],
+            Proxy:     state.nextProxies()[address],
+            Nonce:     state.Nonces[address],
+        }
+        for _, u := range state.Unbonding {
+            if u.Address == address {
+                info.Unbonding = append(info.Unbonding, u)
+            }
+        }
+        c.JSON(200, info)
+    }
+}
+
+// getProposals 全部参数提案及其赞成权重，和当前生效的参数
+func getProposals(n *NetworkNode) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        n.lock.Lock()
+        defer n.lock.Unlock()
+        state := n.bc.State
+        proposals := make([]ProposalInfo, 0, len(state.Proposals))
+        for _, p := range state.Proposals {
+            approved, total := state.approval(p)
+            proposals = append(proposals, ProposalInfo{Proposal: p, Approved: approved, Total: total})
+        }
+        c.JSON(200, gin.H{
+            "params":    state.Params,
+            "proposals": proposals,
+        })
+    }
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+    "crypto/ed25519"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "log"
+)
+
+const (
+    phasePrepare = "prepare"
+    phaseCommit  = "commit"
+)
+
+var errBadConfirmation = errors.New("invalid BFT confirmation")
+
+// DelegateSignature 受托人对某一阶段的区块哈希的签名
+type DelegateSignature struct {
+    Delegate  string
+    Signature string
+}
+
+// Confirmation 受托人对区块的 prepare/commit 两阶段投票，两个阶段都达到法定人数时区块立即不可逆。
+// 它随区块保存，但不参与区块哈希
+type Confirmation struct {
+    Prepares []DelegateSignature
+    Commits  []DelegateSignature
+}
+
+// bftQuorum 在 n 个受托人中达成确认所需的签名数，即 2/3+1
+func bftQuorum(n int) int {
+    return 2*n/3 + 1
+}
+
+// phaseMessage 受托人在某一阶段签名的内容
+func phaseMessage(phase, hash string) []byte {
+    return []byte(phase + ":" + hash)
+}
+
+func signPhase(phase, hash string, privateKey ed25519.PrivateKey) string {
+    return hex.EncodeToString(ed25519.Sign(privateKey, phaseMessage(phase, hash)))
+}
+
+// verifyPhase 校验某一阶段的签名来自 active 中不同的受托人且达到法定人数
+func verifyPhase(phase, hash string, signatures []DelegateSignature, active []string) error {
+    eligible := make(map[string]bool, len(active))
+    for _, delegate := range active {
+        eligible[delegate] = true
+    }
+    signed := make(map[string]bool, len(signatures))
+    for _, sig := range signatures {
+        if !eligible[sig.Delegate] || signed[sig.Delegate] {
+            return fmt.Errorf("%w: %s signature from %s is not from a distinct active delegate", errBadConfirmation, phase, sig.Delegate)
+        }
+        if err := verifySignature(phase, hash, sig); err != nil {
+            return err
+        }
+        signed[sig.Delegate] = true
+    }
+    if len(signed) < bftQuorum(len(active)) {
+        return fmt.Errorf("%w: %d %s signatures, need %d", errBadConfirmation, len(signed), phase, bftQuorum(len(active)))
+    }
+    return nil
+}
+
+// verifySignature 校验受托人对某一阶段的区块哈希的签名
+func verifySignature(phase, hash string, sig DelegateSignature) error {
+    publicKey, err := publicKeyFromAddress(sig.Delegate)
+    if err != nil {
+        return fmt.Errorf("%w: %v", errBadConfirmation, err)
+    }
+    signature, err := hex.DecodeString(sig.Signature)
+    if err != nil || !ed25519.Verify(publicKey, phaseMessage(phase, hash), signature) {
+        return fmt.Errorf("%w: bad %s signature from %s", errBadConfirmation, phase, sig.Delegate)
+    }
+    return nil
+}
+
+// verifyConfirmation 校验区块附带的确认，没有确认的区块只能靠深度达到不可逆
+func verifyConfirmation(block Block, active []string) error {
+    if block.Confirmation == nil {
+        return nil
+    }
+    if err := verifyPhase(phasePrepare, block.Hash, block.Confirmation.Prepares, active); err != nil {
+        return err
+    }
+    return verifyPhase(phaseCommit, block.Hash, block.Confirmation.Commits, active)
+}
+
+// confirmBlock 模拟中的确认轮次：在线的受托人先对链尾区块 prepare，prepare 达到法定人数后再 commit，commit 消息可能丢失；
+// commit 也达到法定人数时把确认保存到区块上，该区块立即不可逆。网络节点之间通过 PhaseVote 消息完成同样的两个阶段
+func (bc *Blockchain) confirmBlock(active []string) bool {
+    block := &bc.Blocks[len(bc.Blocks)-1]
+    var voters []*Node
+    confirmation := &Confirmation{}
+    for _, delegate := range active {
+        node := bc.findNode(delegate)
+        if node == nil || !node.Online {
+            continue
+        }
+        voters = append(voters, node)
+        confirmation.Prepares = append(confirmation.Prepares, DelegateSignature{
+            Delegate:  delegate,
+            Signature: signPhase(phasePrepare, block.Hash, node.privateKey),
+        })
+    }
+    if len(confirmation.Prepares) < bftQuorum(len(active)) {
+        fmt.Printf("Block %d not prepared: %d of %d delegates\n", block.Index, len(confirmation.Prepares), len(active))
+        return false
+    }
+    for _, node := range voters {
+        if rng.Float64() < outageRate {
+            continue
+        }
+        confirmation.Commits = append(confirmation.Commits, DelegateSignature{
+            Delegate:  node.Address,
+            Signature: signPhase(phaseCommit, block.Hash, node.privateKey),
+        })
+    }
+    if len(confirmation.Commits) < bftQuorum(len(active)) {
+        fmt.Printf("Block %d not committed: %d of %d delegates\n", block.Index, len(confirmation.Commits), len(active))
+        return false
+    }
+    block.Confirmation = confirmation
+    if block.Index > bc.LastIrreversible {
+        bc.LastIrreversible = block.Index
+    }
+    return true
+}
+
+// PhaseVote 网络节点广播的本节点受托人对某一高度区块的一个阶段的签名
+type PhaseVote struct {
+    Phase string
+    Index int
+    Hash  string
+    DelegateSignature
+}
+
+// voteRound 网络节点为一个区块收集的投票；active 为该区块所在轮次可出块的受托人，接受该区块之前为空
+type voteRound struct {
+    index     int
+    active    []string
+    prepares  map[string]string
+    commits   map[string]string
+    committed bool
+}
+
+// round 区块 hash 的投票记录，不存在时新建
+func (n *NetworkNode) round(hash string, index int) *voteRound {
+    r, ok := n.rounds[hash]
+    if !ok {
+        r = &voteRound{index: index, prepares: make(map[string]string), commits: make(map[string]string)}
+        n.rounds[hash] = r
+    }
+    return r
+}
+
+// count 来自 active 的签名数
+func (r *voteRound) count(signatures map[string]string) int {
+    count := 0
+    for _, delegate := range r.active {
+        if _, ok := signatures[delegate]; ok {
+            count++
+        }
+    }
+    return count
+}
+
+// signatures 按 active 的顺序列出其中受托人的签名
+func (r *voteRound) signatures(signatures map[string]string) []DelegateSignature {
+    var list []DelegateSignature
+    for _, delegate := range r.active {
+        if sig, ok := signatures[delegate]; ok {
+            list = append(list, DelegateSignature{Delegate: delegate, Signature: sig})
+        }
+    }
+    return list
+}
+
+// startRound 接受或产出链尾区块后开始收集它的投票：本节点是该轮可出块的受托人且未在同一高度 prepare 过其他区块时，
+// 签名并广播 prepare。调用时需持有锁
+func (n *NetworkNode) startRound(block Block) {
+    if !n.bft {
+        return
+    }
+    r := n.round(block.Hash, block.Index)
+    r.active = n.scheduler.activeDelegates()
+    if n.isActive(r.active) && n.prepared[block.Index] == "" {
+        n.prepared[block.Index] = block.Hash
+        n.castVote(r, phasePrepare, block)
+    }
+    n.advanceRound(block.Hash)
+}
+
+// isActive 本节点是否在 active 中
+func (n *NetworkNode) isActive(active []string) bool {
+    for _, delegate := range active {
+        if delegate == n.self.Address {
+            return true
+        }
+    }
+    return false
+}
+
+// castVote 用本节点的私钥签名一个阶段的投票，记入 r 并广播给对等节点
+func (n *NetworkNode) castVote(r *voteRound, phase string, block Block) {
+    vote := PhaseVote{Phase: phase, Index: block.Index, Hash: block.Hash, DelegateSignature: DelegateSignature{
+        Delegate:  n.self.Address,
+        Signature: signPhase(phase, block.Hash, n.self.privateKey),
+    }}
+    if phase == phasePrepare {
+        r.prepares[vote.Delegate] = vote.Signature
+    } else {
+        r.commits[vote.Delegate] = vote.Signature
+    }
+    go n.broadcast(Message{Type: msgVote, Vote: &vote})
+}
+
+// handleVote 记录对等节点广播的签名有效的投票；已不可逆的高度和链尾下一个区块之后的投票直接忽略
+func (n *NetworkNode) handleVote(vote PhaseVote) {
+    n.lock.Lock()
+    defer n.lock.Unlock()
+    if !n.bft || vote.Index <= n.bc.LastIrreversible || vote.Index > len(n.bc.Blocks) {
+        return
+    }
+    if vote.Phase != phasePrepare && vote.Phase != phaseCommit {
+        log.Printf("vote from %s rejected: unknown phase %q", vote.Delegate, vote.Phase)
+        return
+    }
+    if err := verifySignature(vote.Phase, vote.Hash, vote.DelegateSignature); err != nil {
+        log.Printf("vote from %s rejected: %v", vote.Delegate, err)
+        return
+    }
+    r := n.round(vote.Hash, vote.Index)
+    if vote.Phase == phasePrepare {
+        r.prepares[vote.Delegate] = vote.Signature
+    } else {
+        r.commits[vote.Delegate] = vote.Signature
+    }
+    n.advanceRound(vote.Hash)
+}
+
+// advanceRound prepare 达到法定人数且本节点 prepare 过该区块时签名并广播 commit；
+// commit 也达到法定人数时把确认保存到链上的区块，该区块立即不可逆。调用时需持有锁
+func (n *NetworkNode) advanceRound(hash string) {
+    r := n.rounds[hash]
+    if r.active == nil || r.index >= len(n.bc.Blocks) || n.bc.Blocks[r.index].Hash != hash {
+        return
+    }
+    block := &n.bc.Blocks[r.index]
+    quorum := bftQuorum(len(r.active))
+    if !r.committed && n.prepared[r.index] == hash && r.count(r.prepares) >= quorum {
+        r.committed = true
+        n.castVote(r, phaseCommit, *block)
+    }
+    if r.count(r.commits) < quorum || r.count(r.prepares) < quorum {
+        return
+    }
+    block.Confirmation = &Confirmation{Prepares: r.signatures(r.prepares), Commits: r.signatures(r.commits)}
+    if block.Index > n.bc.LastIrreversible {
+        n.bc.LastIrreversible = block.Index
+    }
+    fmt.Printf("Block %d confirmed by %d delegates\n", block.Index, len(block.Confirmation.Commits))
+    if err := n.bc.persist(); err != nil {
+        log.Printf("failed to persist confirmation of block %d: %v", block.Index, err)
+    }
+    for h, round := range n.rounds {
+        if round.index <= n.bc.LastIrreversible {
+            delete(n.rounds, h)
+        }
+    }
+    for index := range n.prepared {
+        if index <= n.bc.LastIrreversible {
+            delete(n.prepared, index)
+        }
+    }
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+    "fmt"
+    "path/filepath"
+    "sort"
+    "time"
+)
+
+// 贿选与合谋的行为模型
+const (
+    modelSeller = "seller" // 比较卡特尔的返还和独立投票的奖励，哪边多就把票卖给哪边
+    modelCartel = "cartel" // 卡特尔成员：只投卡特尔成员，收入继续质押，并把佣金的一部分返还给卖票者
+)
+
+// CollusionConfig 卡特尔的规模、返还给卖票者的佣金百分比，以及要比较的奖励分配规则
+type CollusionConfig struct {
+    CartelSize      int      `json:"cartelSize"`
+    KickbackPercent int      `json:"kickbackPercent"`
+    RewardSharing   []string `json:"rewardSharing"`
+}
+
+func (c *CollusionConfig) validate(candidates int) error {
+    if c.CartelSize <= 0 || c.CartelSize > candidates || c.CartelSize > maxVotesPerVoter {
+        return fmt.Errorf("cartelSize must be 1 to %d", min(candidates, maxVotesPerVoter))
+    }
+    if c.KickbackPercent < 0 || c.KickbackPercent > 100 {
+        return fmt.Errorf("kickbackPercent must be 0 to 100")
+    }
+    for _, rule := range c.RewardSharing {
+        if err := validRewardSharing(rule); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+func validRewardSharing(rule string) error {
+    if rule != RewardProportional && rule != RewardEqual && rule != RewardNone {
+        return fmt.Errorf("unknown reward sharing rule %q", rule)
+    }
+    return nil
+}
+
+// Cartel 互相投票并向只投卡特尔的卖票者返还佣金的候选人联盟
+type Cartel struct {
+    Members  []string
+    Kickback int
+    members  map[string]bool
+}
+
+func newCartel(nodes []Node, kickback int) *Cartel {
+    c := &Cartel{Kickback: kickback, members: make(map[string]bool)}
+    for _, node := range nodes {
+        if node.model == modelCartel {
+            c.Members = append(c.Members, node.Address)
+            c.members[node.Address] = true
+        }
+    }
+    sort.Strings(c.Members)
+    return c
+}
+
+// sold 账户是否只赞成卡特尔成员，卡特尔成员自己不算卖票者
+func (c *Cartel) sold(s *State, account string) bool {
+    if c.members[account] || len(s.Approvals[account]) == 0 {
+        return false
+    }
+    for _, candidate := range s.Approvals[account] {
+        if !c.members[candidate] {
+            return false
+        }
+    }
+    return true
+}
+
+// seats 当选受托人中卡特尔成员的席位数
+func (c *Cartel) seats(delegates []string) int {
+    seats := 0
+    for _, delegate := range delegates {
+        if c.members[delegate] {
+            seats++
+        }
+    }
+    return seats
+}
+
+// backing 除 exclude 以外支持 candidate 的账户数、质押量，以及其中卖票者的质押量
+func (bc *Blockchain) backing(candidate, exclude string) (int, int, int) {
+    count, stake, sold := 0, 0, 0
+    for _, backer := range bc.State.backers(candidate, bc.State.Proxies) {
+        if backer == exclude {
+            continue
+        }
+        count++
+        stake += bc.State.Staked[backer]
+        if bc.cartel != nil && bc.cartel.sold(bc.State, backer) {
+            sold += bc.State.Staked[backer]
+        }
+    }
+    return count, stake, sold
+}
+
+// expectedReward 按上一届的当选结果估算节点赞成 candidate 一届能分得的出块奖励，
+// kickback 为真时计入卡特尔成员返还给卖票者的部分
+func (bc *Blockchain) expectedReward(node *Node, candidate string, kickback bool) float64 {
+    delegates := bc.Elections[len(bc.Elections)-1].Delegates
+    elected := false
+    for _, delegate := range delegates {
+        elected = elected || delegate == candidate
+    }
+    if !elected {
+        return 0
+    }
+    stake := float64(max(bc.State.Staked[node.Address], 1))
+    blocks := float64(bc.State.Params.epochLength()) / float64(len(delegates))
+    reward := float64(bc.State.Params.BlockReward)
+    commission := float64(bc.State.commissionOf(candidate)) / 100
+    count, backing, sold := bc.backing(candidate, node.Address)
+    expected := 0.0
+    switch bc.State.Params.RewardSharing {
+    case RewardEqual:
+        expected = blocks * reward * (1 - commission) / float64(count+1)
+    case RewardProportional:
+        expected = blocks * reward * (1 - commission) * stake / (float64(backing) + stake)
+    }
+    if kickback && bc.cartel.members[candidate] {
+        expected += blocks * reward * commission * float64(bc.cartel.Kickback) / 100 * stake / (float64(sold) + stake)
+    }
+    return expected
+}
+
+// sellerApprovals 卖票者在只投卡特尔和独立投票之间选择预期奖励更多的一边；
+// 独立投票时赞成预期奖励最多的候选人。创世时还没有报价，随机投票
+func (bc *Blockchain) sellerApprovals(node *Node) []string {
+    if bc.State == nil || len(bc.Elections) == 0 {
+        return randomApprovals(bc.Candidates)
+    }
+    candidates := bc.State.candidates()
+    rewards := make(map[string]float64, len(candidates))
+    for _, candidate := range candidates {
+        rewards[candidate] = bc.expectedReward(node, candidate, false)
+    }
+    sort.SliceStable(candidates, func(i, j int) bool {
+        return rewards[candidates[i]] > rewards[candidates[j]]
+    })
+    free := candidates[:min(maxVotesPerVoter, len(candidates))]
+    if bc.cartel == nil {
+        return free
+    }
+    freeReward, soldReward := 0.0, 0.0
+    for _, candidate := range free {
+        freeReward += rewards[candidate]
+    }
+    for _, member := range bc.cartel.Members {
+        soldReward += bc.expectedReward(node, member, true)
+    }
+    if soldReward > freeReward {
+        return bc.cartel.Members
+    }
+    return free
+}
+
+// payKickbacks 卡特尔成员把在 epoch 届抽取的佣金按 Kickback 百分比返还给只投卡特尔的支持者，按质押量分配
+func (bc *Blockchain) payKickbacks(epoch int) {
+    if bc.cartel == nil || bc.cartel.Kickback == 0 {
+        return
+    }
+    for _, member := range bc.cartel.Members {
+        node := bc.findNode(member)
+        pool := min(bc.State.Earnings[epoch][member].Commission*bc.cartel.Kickback/100, bc.State.Balances[member])
+        var sellers []string
+        total := 0
+        for _, backer := range bc.State.backers(member, bc.State.Proxies) {
+            if bc.cartel.sold(bc.State, backer) {
+                sellers = append(sellers, backer)
+                total += bc.State.Staked[backer]
+            }
+        }
+        if pool == 0 || total == 0 {
+            continue
+        }
+        for _, seller := range sellers {
+            if amount := pool * bc.State.Staked[seller] / total; amount > 0 {
+                bc.signAndSubmit(node, Transaction{Type: TxTransfer, To: seller, Amount: amount})
+            }
+        }
+        fmt.Printf("Cartel member %s pays %d in kickbacks to %d sellers\n", member, pool, len(sellers))
+    }
+}
+
+// capture 卡特尔的席位在某届首次超过受托人数的 num/den 的届数，从未超过为0
+func (c *Cartel) capture(elections []ElectionResult, num, den int) int {
+    for _, result := range elections {
+        if den*c.seats(result.Delegates) > num*len(result.Delegates) {
+            return result.Epoch
+        }
+    }
+    return 0
+}
+
+// soldShare 卖票者的质押占全部质押的比例
+func (c *Cartel) soldShare(s *State) float64 {
+    sold, total := 0, 0
+    for account, staked := range s.Staked {
+        total += staked
+        if c.sold(s, account) {
+            sold += staked
+        }
+    }
+    if total == 0 {
+        return 0
+    }
+    return float64(sold) / float64(total)
+}
+
+// printCapture 打印卡特尔每届的席位
+func printCapture(c *Cartel, elections []ElectionResult) {
+    fmt.Printf("Cartel seats by epoch (%d members, %d%% kickback):\n", len(c.Members), c.Kickback)
+    for _, result := range elections {
+        fmt.Printf("epoch %d: %d/%d\n", result.Epoch, c.seats(result.Delegates), len(result.Delegates))
+    }
+}
+
+func epochOrDash(epoch int) string {
+    if epoch == 0 {
+        return "-"
+    }
+    return fmt.Sprint(epoch)
+}
+
+// runCollusionStudy 对每种奖励分配规则用同一种子和创世时间各运行一次模拟，
+// 最后比较卡特尔的席位首次超过 1/3、1/2 和 2/3 的届数；dataDir 非空时每种规则的链写入以规则命名的子目录
+func runCollusionStudy(config SimConfig, bft bool, dataDir string) {
+    if config.Seed == 0 {
+        config.Seed = time.Now().UnixNano()
+    }
+    if config.GenesisTime == 0 {
+        config.GenesisTime = time.Now().Unix()
+    }
+    rules := config.Collusion.RewardSharing
+    if len(rules) == 0 {
+        rules = []string{config.RewardSharing}
+    }
+    var results []*Blockchain
+    for _, rule := range rules {
+        fmt.Printf("=== Reward sharing: %s ===\n", rule)
+        config.RewardSharing = rule
+        dir := dataDir
+        if dir != "" {
+            dir = filepath.Join(dataDir, rule)
+        }
+        results = append(results, runSimulation(config, bft, dir))
+    }
+    fmt.Printf("Cartel capture by reward sharing rule (%d members, %d%% kickback, %d epochs):\n",
+        config.Collusion.CartelSize, config.Collusion.KickbackPercent, config.Epochs)
+    fmt.Println("rule          >1/3  >1/2  >2/3  final seats  sold stake")
+    for i, bc := range results {
+        c := bc.cartel
+        last := bc.Elections[len(bc.Elections)-1]
+        seats := fmt.Sprintf("%d/%d", c.seats(last.Delegates), len(last.Delegates))
+        fmt.Printf("%-12s  %4s  %4s  %4s  %11s  %9.1f%%\n", rules[i],
+            epochOrDash(c.capture(bc.Elections, 1, 3)), epochOrDash(c.capture(bc.Elections, 1, 2)),
+            epochOrDash(c.capture(bc.Elections, 2, 3)), seats, 100*c.soldShare(bc.State))
+    }
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+    "fmt"
+    "sort"
+)
+
+const (
+    defaultDelegateCount = 21
+    maxVotesPerVoter     = 30
+)
+
+// CandidateTally 候选人的得票统计，Votes 为投票人权重之和
+type CandidateTally struct {
+    Candidate string
+    Votes     int
+    Voters    int
+}
+
+// ElectionResult 一届选举的结果
+type ElectionResult struct {
+    Epoch     int
+    Tallies   []CandidateTally
+    Delegates []string
+}
+
+// tallyVotes 按投票权重统计赞成票：每个投票人最多支持 maxVotesPerVoter 个不同的候选人，
+// 对每个候选人都计入其全部权重；权重为投票人自己的质押量加上委托给它的质押量
+func tallyVotes(weights map[string]int, candidates []string, votes []Vote) []CandidateTally {
+    tallies := make(map[string]*CandidateTally, len(candidates))
+    for _, candidate := range candidates {
+        tallies[candidate] = &CandidateTally{Candidate: candidate}
+    }
+
+    approved := make(map[string]map[string]bool)
+    for _, vote := range votes {
+        weight := weights[vote.Voter]
+        tally, isCandidate := tallies[vote.Candidate]
+        if weight <= 0 || !isCandidate {
+            continue
+        }
+        if approved[vote.Voter] == nil {
+            approved[vote.Voter] = make(map[string]bool)
+        }
+        if approved[vote.Voter][vote.Candidate] || len(approved[vote.Voter]) >= maxVotesPerVoter {
+            continue
+        }
+        approved[vote.Voter][vote.Candidate] = true
+        tally.Votes += weight
+        tally.Voters++
+    }
+
+    result := make([]CandidateTally, 0, len(tallies))
+    for _, candidate := range candidates {
+        result = append(result, *tallies[candidate])
+    }
+    sort.SliceStable(result, func(i, j int) bool {
+        if result[i].Votes != result[j].Votes {
+            return result[i].Votes > result[j].Votes
+        }
+        return result[i].Candidate < result[j].Candidate
+    })
+    return result
+}
+
+// electDelegates 取得票最多且得票大于0的前 count 个候选人
+func electDelegates(tallies []CandidateTally, count int) []string {
+    delegates := make([]string, 0, count)
+    for _, tally := range tallies {
+        if len(delegates) == count || tally.Votes == 0 {
+            break
+        }
+        delegates = append(delegates, tally.Candidate)
+    }
+    return delegates
+}
+
+// runElection 统计链上状态中的质押和投票，选出第 epoch 届的受托人，并更新节点的得票数
+func (bc *Blockchain) runElection(epoch int) ElectionResult {
+    tallies := bc.State.tally(bc.State.candidates())
+    received := make(map[string]int, len(tallies))
+    for _, tally := range tallies {
+        received[tally.Candidate] = tally.Votes
+    }
+    for i := range bc.Nodes {
+        bc.Nodes[i].VoteCount = received[bc.Nodes[i].Address]
+    }
+    result := ElectionResult{
+        Epoch:     epoch,
+        Tallies:   tallies,
+        Delegates: electDelegates(tallies, bc.State.paramsAt(epoch).DelegateCount),
+    }
+    bc.Elections = append(bc.Elections, result)
+    return result
+}
+
+func printElectionResult(result ElectionResult) {
+    fmt.Printf("Election for epoch %d elected %d delegates:\n", result.Epoch, len(result.Delegates))
+    for i, delegate := range result.Delegates {
+        fmt.Printf("%d. %s - Votes: %d, Voters: %d\n", i+1, delegate, result.Tallies[i].Votes, result.Tallies[i].Voters)
+    }
+}
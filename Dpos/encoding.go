@@ -0,0 +1,108 @@
+package main
+
+import (
+    "bytes"
+    "crypto/sha256"
+    "encoding/binary"
+    "encoding/hex"
+)
+
+// 区块的规范二进制编码：整数为大端定长，字符串带 uint32 长度前缀，
+// 区块头通过 Merkle 根承诺全部交易
+
+const (
+    merkleLeafPrefix = 0x00
+    merkleNodePrefix = 0x01
+)
+
+type encoder struct {
+    buf bytes.Buffer
+}
+
+func (e *encoder) writeInt64(v int64) {
+    var b [8]byte
+    binary.BigEndian.PutUint64(b[:], uint64(v))
+    e.buf.Write(b[:])
+}
+
+func (e *encoder) writeString(s string) {
+    var b [4]byte
+    binary.BigEndian.PutUint32(b[:], uint32(len(s)))
+    e.buf.Write(b[:])
+    e.buf.WriteString(s)
+}
+
+// encodeHeader 编码区块头，不包含区块哈希和出块签名
+func encodeHeader(block Block) []byte {
+    var e encoder
+    e.writeInt64(int64(block.Index))
+    e.writeInt64(block.Timestamp)
+    e.writeInt64(block.Slot)
+    e.writeInt64(int64(block.Epoch))
+    e.writeString(block.Producer)
+    e.writeString(block.PreviousHash)
+    e.writeString(block.MerkleRoot)
+    e.writeInt64(int64(block.Nonce))
+    return e.buf.Bytes()
+}
+
+func encodeTransaction(tx Transaction) []byte {
+    var e encoder
+    e.writeString(tx.Type)
+    e.writeString(tx.From)
+    e.writeString(tx.To)
+    e.writeInt64(int64(tx.Amount))
+    e.writeInt64(int64(len(tx.Candidates)))
+    for _, candidate := range tx.Candidates {
+        e.writeString(candidate)
+    }
+    e.writeInt64(int64(len(tx.Evidence)))
+    for _, h := range tx.Evidence {
+        e.buf.Write(encodeHeader(h))
+        e.writeString(h.Hash)
+        e.writeString(h.Signature)
+    }
+    if tx.Proposal != nil {
+        e.writeString(tx.Proposal.Param)
+        e.writeInt64(int64(tx.Proposal.Value))
+        e.writeInt64(int64(tx.Proposal.Epoch))
+    } else {
+        e.writeString("")
+    }
+    e.writeInt64(tx.Nonce)
+    e.writeString(tx.Signature)
+    return e.buf.Bytes()
+}
+
+// merkleRoot 以交易为叶子计算 Merkle 根；叶子与内部节点使用不同前缀，
+// 奇数个节点时最后一个直接进入上一层
+func merkleRoot(transactions []Transaction) string {
+    level := make([][]byte, 0, len(transactions))
+    for _, tx := range transactions {
+        level = append(level, merkleHash(merkleLeafPrefix, encodeTransaction(tx)))
+    }
+    if len(level) == 0 {
+        return hex.EncodeToString(make([]byte, sha256.Size))
+    }
+    for len(level) > 1 {
+        next := make([][]byte, 0, (len(level)+1)/2)
+        for i := 0; i < len(level); i += 2 {
+            if i+1 == len(level) {
+                next = append(next, level[i])
+                continue
+            }
+            next = append(next, merkleHash(merkleNodePrefix, level[i], level[i+1]))
+        }
+        level = next
+    }
+    return hex.EncodeToString(level[0])
+}
+
+func merkleHash(prefix byte, parts ...[]byte) []byte {
+    h := sha256.New()
+    h.Write([]byte{prefix})
+    for _, part := range parts {
+        h.Write(part)
+    }
+    return h.Sum(nil)
+}
@@ -0,0 +1,115 @@
+package main
+
+import "testing"
+
+func sampleTransaction() Transaction {
+    return Transaction{
+        Type:       TxVote,
+        From:       "alice",
+        To:         "bob",
+        Amount:     10,
+        Candidates: []string{"carol", "dave"},
+        Evidence:   []Block{{Index: 3, Timestamp: 9, Slot: 3, Epoch: 1, Producer: "eve", PreviousHash: "p", MerkleRoot: "m", Hash: "h", Signature: "s"}},
+        Proposal:   &ParamChange{Param: ParamBlockReward, Value: 50, Epoch: 4},
+        Nonce:      7,
+        Signature:  "sig",
+    }
+}
+
+func sampleBlock() Block {
+    block := Block{
+        Index:        5,
+        Timestamp:    1700000015,
+        Slot:         5,
+        Epoch:        1,
+        Producer:     "alice",
+        PreviousHash: "prev",
+        Transactions: []Transaction{sampleTransaction()},
+        Nonce:        1,
+    }
+    block.MerkleRoot = merkleRoot(block.Transactions)
+    return block
+}
+
+func TestCalculateHashCoversHeaderFields(t *testing.T) {
+    cases := map[string]func(b *Block){
+        "Index":        func(b *Block) { b.Index++ },
+        "Timestamp":    func(b *Block) { b.Timestamp++ },
+        "Slot":         func(b *Block) { b.Slot++ },
+        "Epoch":        func(b *Block) { b.Epoch++ },
+        "Producer":     func(b *Block) { b.Producer = "bob" },
+        "PreviousHash": func(b *Block) { b.PreviousHash = "other" },
+        "Nonce":        func(b *Block) { b.Nonce++ },
+        "Transactions": func(b *Block) { b.Transactions[0].Amount++ },
+    }
+    base := calculateHash(sampleBlock())
+    for field, mutate := range cases {
+        block := sampleBlock()
+        mutate(&block)
+        if calculateHash(block) == base {
+            t.Errorf("changing %s does not change the block hash", field)
+        }
+    }
+}
+
+// calculateHash 会按交易重算 Merkle 根，因此区块头中记录的 Merkle 根通过 headerHash 检查
+func TestHeaderHashCoversMerkleRoot(t *testing.T) {
+    block := sampleBlock()
+    base := headerHash(block)
+    block.MerkleRoot = merkleRoot(nil)
+    if headerHash(block) == base {
+        t.Error("changing MerkleRoot does not change the header hash")
+    }
+}
+
+func TestMerkleRootCoversTransactionFields(t *testing.T) {
+    cases := map[string]func(tx *Transaction){
+        "Type":                 func(tx *Transaction) { tx.Type = TxTransfer },
+        "From":                 func(tx *Transaction) { tx.From = "mallory" },
+        "To":                   func(tx *Transaction) { tx.To = "mallory" },
+        "Amount":               func(tx *Transaction) { tx.Amount++ },
+        "Candidates":           func(tx *Transaction) { tx.Candidates[1] = "mallory" },
+        "Candidates length":    func(tx *Transaction) { tx.Candidates = tx.Candidates[:1] },
+        "Candidates split":     func(tx *Transaction) { tx.Candidates = []string{"caroldave"} },
+        "Evidence header":      func(tx *Transaction) { tx.Evidence[0].Slot++ },
+        "Evidence hash":        func(tx *Transaction) { tx.Evidence[0].Hash = "other" },
+        "Evidence signature":   func(tx *Transaction) { tx.Evidence[0].Signature = "other" },
+        "Evidence length":      func(tx *Transaction) { tx.Evidence = nil },
+        "Proposal param":       func(tx *Transaction) { tx.Proposal.Param = ParamSlotInterval },
+        "Proposal value":       func(tx *Transaction) { tx.Proposal.Value++ },
+        "Proposal epoch":       func(tx *Transaction) { tx.Proposal.Epoch++ },
+        "Proposal removed":     func(tx *Transaction) { tx.Proposal = nil },
+        "Nonce":                func(tx *Transaction) { tx.Nonce++ },
+        "Signature":            func(tx *Transaction) { tx.Signature = "forged" },
+        "From and To boundary": func(tx *Transaction) { tx.From, tx.To = "aliceb", "ob" },
+    }
+    base := merkleRoot([]Transaction{sampleTransaction()})
+    for field, mutate := range cases {
+        tx := sampleTransaction()
+        mutate(&tx)
+        if merkleRoot([]Transaction{tx}) == base {
+            t.Errorf("changing %s does not change the merkle root", field)
+        }
+    }
+}
+
+func TestMerkleRootCoversOrderAndCount(t *testing.T) {
+    a, b := sampleTransaction(), sampleTransaction()
+    b.Nonce++
+    c := sampleTransaction()
+    c.Nonce += 2
+    roots := map[string]string{
+        "empty": merkleRoot(nil),
+        "a":     merkleRoot([]Transaction{a}),
+        "ab":    merkleRoot([]Transaction{a, b}),
+        "ba":    merkleRoot([]Transaction{b, a}),
+        "abc":   merkleRoot([]Transaction{a, b, c}),
+    }
+    seen := make(map[string]string, len(roots))
+    for name, root := range roots {
+        if other, ok := seen[root]; ok {
+            t.Errorf("transaction lists %s and %s have the same merkle root", name, other)
+        }
+        seen[root] = name
+    }
+}
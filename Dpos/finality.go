@@ -0,0 +1,100 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "log"
+)
+
+var (
+    errNotLonger    = errors.New("fork is not longer than the current chain")
+    errRevertsFinal = errors.New("fork reverts an irreversible block")
+)
+
+// lastIrreversible 从链尾向前统计链尾所在届中属于 active 的出块人，当某个区块之后出块的不同受托人超过 active 的三分之二时，
+// 该区块及之前的区块都不可逆；已被选下或移出的受托人不计入，统计在届的边界停止。
+// 带有 BFT 确认的区块本身即不可逆，创世区块总是不可逆
+func lastIrreversible(blocks []Block, active []string) int {
+    members := make(map[string]bool, len(active))
+    for _, delegate := range active {
+        members[delegate] = true
+    }
+    producers := make(map[string]bool)
+    epoch := blocks[len(blocks)-1].Epoch
+    for i := len(blocks) - 1; i > 0; i-- {
+        if blocks[i].Confirmation != nil {
+            return blocks[i].Index
+        }
+        if blocks[i].Epoch != epoch {
+            break
+        }
+        if members[blocks[i].Producer] {
+            producers[blocks[i].Producer] = true
+        }
+        if 3*len(producers) > 2*len(active) {
+            return blocks[i-1].Index
+        }
+    }
+    return 0
+}
+
+// updateLastIrreversible 按链尾所在届当前可出块的受托人推进不可逆区块，不可逆高度只增不减
+func (bc *Blockchain) updateLastIrreversible(active []string) {
+    if lib := lastIrreversible(bc.Blocks, active); lib > bc.LastIrreversible {
+        bc.LastIrreversible = lib
+    }
+}
+
+// replaceChain 切换到更长的合法分叉，分叉必须包含当前的不可逆区块；返回推进到新链尾的调度器
+func (bc *Blockchain) replaceChain(blocks []Block) (*Scheduler, error) {
+    if len(blocks) <= len(bc.Blocks) {
+        return nil, errNotLonger
+    }
+    lib := bc.Blocks[bc.LastIrreversible]
+    if blocks[lib.Index].Hash != lib.Hash {
+        return nil, fmt.Errorf("%w: block %d", errRevertsFinal, lib.Index)
+    }
+    state, scheduler, err := replay(blocks, bc.Candidates, bc.Params)
+    if err != nil {
+        return nil, err
+    }
+    bc.Blocks = append([]Block(nil), blocks...)
+    bc.State = state
+    bc.syncTokenAmounts()
+    bc.updateLastIrreversible(scheduler.activeDelegates())
+    if err := bc.persist(); err != nil {
+        log.Printf("failed to persist chain: %v", err)
+    }
+    return scheduler, nil
+}
+
+// extendChain 在链尾之后逐块校验并执行 blocks，scheduler 为位于当前链尾的调度器；
+// 只回放新区块，不修改 scheduler，返回推进到新链尾的调度器
+func (bc *Blockchain) extendChain(scheduler *Scheduler, blocks []Block) (*Scheduler, error) {
+    state := bc.State.clone()
+    s := scheduler.clone(state)
+    tip := bc.Blocks[len(bc.Blocks)-1]
+    if _, err := replayBlocks(state, s, append([]Block{tip}, blocks...), 1); err != nil {
+        return nil, err
+    }
+    bc.Blocks = append(bc.Blocks, blocks...)
+    bc.State = state
+    bc.syncTokenAmounts()
+    bc.updateLastIrreversible(s.activeDelegates())
+    if err := bc.persist(); err != nil {
+        log.Printf("failed to persist chain: %v", err)
+    }
+    return s, nil
+}
+
+// simulateFinalFork 构造一个改写了不可逆区块的更长分叉，演示节点拒绝切换
+func (bc *Blockchain) simulateFinalFork() {
+    fork := append([]Block(nil), bc.Blocks...)
+    fork = append(fork, fork[len(fork)-1])
+    lib := &fork[bc.LastIrreversible]
+    lib.Nonce++
+    lib.Hash = calculateHash(*lib)
+    if _, err := bc.replaceChain(fork); err != nil {
+        fmt.Printf("Fork rejected: %v\n", err)
+    }
+}
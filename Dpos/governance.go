@@ -0,0 +1,252 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+)
+
+const (
+    ParamDelegateCount = "delegateCount"
+    ParamSlotInterval  = "slotInterval"
+    ParamBlockReward   = "blockReward"
+
+    ProposalPending  = "pending"
+    ProposalAdopted  = "adopted"
+    ProposalRejected = "rejected"
+
+    minProposalLead  = 2 // 提案的生效届数至少比当前届晚 minProposalLead 届，留出完整一届用于表决
+    maxSlotInterval  = 60
+    maxBlockReward   = 1000
+    proposalRate     = 0.01 // 模拟每个时隙有人发起参数提案的概率
+    approvalsPerSlot = 2
+)
+
+var (
+    errBadProposal = errors.New("invalid proposal")
+    errBadApproval = errors.New("invalid approval")
+)
+
+// ParamChange 提议在第 Epoch 届开始时将参数 Param 改为 Value
+type ParamChange struct {
+    Param string
+    Value int
+    Epoch int
+}
+
+// Proposal 链上的参数提案，Approvals 为赞成的账户及其赞成时的投票权重
+type Proposal struct {
+    ID        int
+    Proposer  string
+    Change    ParamChange
+    Approvals map[string]int
+    Status    string
+}
+
+// defaultParams 未经治理修改的共识参数
+func defaultParams(delegateCount int) Params {
+    return Params{
+        DelegateCount:        delegateCount,
+        SlotInterval:         defaultSlotInterval,
+        BlockReward:          defaultBlockReward,
+        RewardSharing:        RewardProportional,
+        MaxConsecutiveMisses: defaultMaxConsecutiveMisses,
+        SlashPercent:         defaultSlashPercent,
+        VoterSlashPercent:    defaultVoterSlashPercent,
+    }
+}
+
+// with 返回应用了 change 之后的参数
+func (p Params) with(change ParamChange) Params {
+    switch change.Param {
+    case ParamDelegateCount:
+        p.DelegateCount = change.Value
+    case ParamSlotInterval:
+        p.SlotInterval = int64(change.Value)
+    case ParamBlockReward:
+        p.BlockReward = change.Value
+    }
+    return p
+}
+
+// checkChange 校验参数名和取值范围，受托人数不能超过未被监禁或罚没的候选人数
+func (s *State) checkChange(change ParamChange) error {
+    switch change.Param {
+    case ParamDelegateCount:
+        if eligible := len(s.candidates()); change.Value < 1 || change.Value > eligible {
+            return fmt.Errorf("%w: delegate count %d is outside 1 to %d", errBadProposal, change.Value, eligible)
+        }
+    case ParamSlotInterval:
+        if change.Value < 1 || change.Value > maxSlotInterval {
+            return fmt.Errorf("%w: slot interval %d is outside 1 to %d", errBadProposal, change.Value, maxSlotInterval)
+        }
+    case ParamBlockReward:
+        if change.Value < 0 || change.Value > maxBlockReward {
+            return fmt.Errorf("%w: block reward %d is outside 0 to %d", errBadProposal, change.Value, maxBlockReward)
+        }
+    default:
+        return fmt.Errorf("%w: unknown parameter %q", errBadProposal, change.Param)
+    }
+    return nil
+}
+
+// applyProposal 有质押的账户提议修改参数，至少 minProposalLead 届后生效，提案人自动赞成
+func (s *State) applyProposal(tx Transaction) error {
+    if tx.Proposal == nil {
+        return fmt.Errorf("%w: missing parameter change", errBadProposal)
+    }
+    if s.Staked[tx.From] == 0 {
+        return fmt.Errorf("%w: %s has no stake", errBadProposal, tx.From)
+    }
+    change := *tx.Proposal
+    if err := s.checkChange(change); err != nil {
+        return err
+    }
+    if change.Epoch < s.Epoch+minProposalLead {
+        return fmt.Errorf("%w: epoch %d is earlier than %d", errBadProposal, change.Epoch, s.Epoch+minProposalLead)
+    }
+    s.Proposals = append(s.Proposals, Proposal{
+        ID:        len(s.Proposals) + 1,
+        Proposer:  tx.From,
+        Change:    change,
+        Approvals: map[string]int{tx.From: s.votingWeights(s.nextProxies())[tx.From]},
+        Status:    ProposalPending,
+    })
+    return nil
+}
+
+// applyApproval 有质押的账户赞成编号为 Amount 的提案，记下此时的投票权重，只能在提案生效的前一届结束前表决，赞成后不能撤回
+func (s *State) applyApproval(tx Transaction) error {
+    if tx.Amount < 1 || tx.Amount > len(s.Proposals) {
+        return fmt.Errorf("%w: no proposal %d", errBadApproval, tx.Amount)
+    }
+    p := &s.Proposals[tx.Amount-1]
+    if p.Status != ProposalPending || s.Epoch >= p.Change.Epoch {
+        return fmt.Errorf("%w: proposal %d is closed", errBadApproval, p.ID)
+    }
+    if s.Staked[tx.From] == 0 {
+        return fmt.Errorf("%w: %s has no stake", errBadApproval, tx.From)
+    }
+    if _, ok := p.Approvals[tx.From]; ok {
+        return fmt.Errorf("%w: %s already approved proposal %d", errBadApproval, tx.From, p.ID)
+    }
+    p.Approvals[tx.From] = s.votingWeights(s.nextProxies())[tx.From]
+    return nil
+}
+
+// approval 提案获得的赞成权重和全部投票权重，按下一届生效的代理关系计算，委托了代理的账户随代理表决。
+// 每个赞成取赞成时与当前投票权重中的较小者，赞成后转入的质押不计入，转出或委托出去的质押也不会被重复计入
+func (s *State) approval(p Proposal) (int, int) {
+    approved, total := 0, 0
+    for account, weight := range s.votingWeights(s.nextProxies()) {
+        total += weight
+        if cast, ok := p.Approvals[account]; ok {
+            approved += min(cast, weight)
+        }
+    }
+    return approved, total
+}
+
+// passes 赞成权重超过全部投票权重的 2/3
+func (s *State) passes(p Proposal) bool {
+    approved, total := s.approval(p)
+    return total > 0 && 3*approved > 2*total
+}
+
+// paramsAt 第 epoch 届生效的参数：在当前参数上依次应用到该届为止获得超级多数的待定提案。
+// 表决在生效届开始前截止，因此上一届结束时的结果就是该届开始时的结果
+func (s *State) paramsAt(epoch int) Params {
+    params := s.Params
+    for _, p := range s.Proposals {
+        if p.Status == ProposalPending && p.Change.Epoch <= epoch && s.passes(p) {
+            params = params.with(p.Change)
+        }
+    }
+    return params
+}
+
+// enactProposals 新一届开始时结算到期的提案，通过的写入当前参数，未通过的作废
+func (s *State) enactProposals(epoch int) {
+    params := s.paramsAt(epoch)
+    for i := range s.Proposals {
+        p := &s.Proposals[i]
+        if p.Status != ProposalPending || p.Change.Epoch > epoch {
+            continue
+        }
+        if s.passes(*p) {
+            p.Status = ProposalAdopted
+        } else {
+            p.Status = ProposalRejected
+        }
+    }
+    s.Params = params
+}
+
+// randomChange 在当前参数附近随机提出一个修改
+func (s *State) randomChange() ParamChange {
+    change := ParamChange{Epoch: s.Epoch + minProposalLead + rng.Intn(2)}
+    switch rng.Intn(3) {
+    case 0:
+        change.Param = ParamDelegateCount
+        change.Value = s.Params.DelegateCount + rng.Intn(5) - 2
+    case 1:
+        change.Param = ParamSlotInterval
+        change.Value = int(s.Params.SlotInterval) + rng.Intn(3) - 1
+    default:
+        change.Param = ParamBlockReward
+        change.Value = s.Params.BlockReward + 10*(rng.Intn(5)-2)
+    }
+    return change
+}
+
+// simulateGovernance 偶尔由随机的质押账户发起参数提案，每个时隙若干质押账户赞成仍在表决中的随机提案
+func (bc *Blockchain) simulateGovernance() {
+    if node := &bc.Nodes[rng.Intn(len(bc.Nodes))]; bc.State.Staked[node.Address] > 0 && rng.Float64() < proposalRate {
+        change := bc.State.randomChange()
+        if _, err := bc.signAndSubmit(node, Transaction{Type: TxPropose, Proposal: &change}); err == nil {
+            fmt.Printf("Proposal to set %s to %d from epoch %d submitted by %s\n", change.Param, change.Value, change.Epoch, node.Address)
+        }
+    }
+    var open []int
+    for _, p := range bc.State.Proposals {
+        if p.Status == ProposalPending && bc.State.Epoch < p.Change.Epoch {
+            open = append(open, p.ID)
+        }
+    }
+    if len(open) == 0 {
+        return
+    }
+    for i := 0; i < approvalsPerSlot; i++ {
+        node := &bc.Nodes[rng.Intn(len(bc.Nodes))]
+        id := open[rng.Intn(len(open))]
+        if _, approved := bc.State.Proposals[id-1].Approvals[node.Address]; approved || bc.State.Staked[node.Address] == 0 || bc.hasApproval(node.Address, id) {
+            continue
+        }
+        bc.signAndSubmit(node, Transaction{Type: TxApprove, Amount: id})
+    }
+}
+
+// hasApproval 交易池中是否已有 address 对提案 id 的赞成
+func (bc *Blockchain) hasApproval(address string, id int) bool {
+    for _, tx := range bc.Transactions {
+        if tx.Type == TxApprove && tx.From == address && tx.Amount == id {
+            return true
+        }
+    }
+    return false
+}
+
+// printProposals 打印各提案的内容、赞成比例和结果
+func printProposals(s *State) {
+    fmt.Printf("Governance proposals: %d\n", len(s.Proposals))
+    for _, p := range s.Proposals {
+        approved, total := s.approval(p)
+        share := 0.0
+        if total > 0 {
+            share = 100 * float64(approved) / float64(total)
+        }
+        fmt.Printf("%d. %s = %d from epoch %d by %s - approvals: %d (%.1f%%), %s\n",
+            p.ID, p.Change.Param, p.Change.Value, p.Change.Epoch, p.Proposer, len(p.Approvals), share, p.Status)
+    }
+    fmt.Printf("Parameters: delegate count %d, slot interval %ds, block reward %d, epoch length %d blocks\n",
+        s.Params.DelegateCount, s.Params.SlotInterval, s.Params.BlockReward, s.Params.epochLength())
+}
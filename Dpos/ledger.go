@@ -0,0 +1,81 @@
+package main
+
+import (
+    "fmt"
+)
+
+// applyTransfer 从可用余额中转账
+func (s *State) applyTransfer(tx Transaction) error {
+    if tx.Amount <= 0 {
+        return errBadAmount
+    }
+    if s.Balances[tx.From] < tx.Amount {
+        return fmt.Errorf("%w: %s has %d, needs %d", errOverdraft, tx.From, s.Balances[tx.From], tx.Amount)
+    }
+    s.Balances[tx.From] -= tx.Amount
+    s.Balances[tx.To] += tx.Amount
+    return nil
+}
+
+// submitTransaction 将签名有效的交易放入待打包队列
+func (bc *Blockchain) submitTransaction(tx Transaction) error {
+    if !verifyTransactionSignature(tx) {
+        return errBadSignature
+    }
+    bc.Transactions = append(bc.Transactions, tx)
+    return nil
+}
+
+// checkTransaction 在链上状态的副本上依次执行待打包交易和 tx，检查 tx 的序号、余额等能否在之后的区块中生效
+func (bc *Blockchain) checkTransaction(tx Transaction) error {
+    state := bc.State.clone()
+    for _, pending := range bc.Transactions {
+        state.applyTransaction(pending)
+    }
+    return state.applyTransaction(tx)
+}
+
+// nextNonce 账户下一笔交易应使用的序号，计入尚未打包的交易
+func (bc *Blockchain) nextNonce(address string) int64 {
+    nonce := bc.State.Nonces[address]
+    for _, tx := range bc.Transactions {
+        if tx.From == address && tx.Nonce > nonce {
+            nonce = tx.Nonce
+        }
+    }
+    return nonce + 1
+}
+
+// syncTokenAmounts 用链上状态刷新节点持币量，包括可用、质押和解绑中的代币
+func (bc *Blockchain) syncTokenAmounts() {
+    unbonding := make(map[string]int)
+    for _, u := range bc.State.Unbonding {
+        unbonding[u.Address] += u.Amount
+    }
+    for i := range bc.Nodes {
+        address := bc.Nodes[i].Address
+        bc.Nodes[i].TokenAmount = bc.State.Balances[address] + bc.State.Staked[address] + unbonding[address]
+    }
+}
+
+// signAndSubmit 由节点填写序号、签名并提交交易，返回签名后的交易
+func (bc *Blockchain) signAndSubmit(node *Node, tx Transaction) (Transaction, error) {
+    tx.From = node.Address
+    tx.Nonce = bc.nextNonce(node.Address)
+    signTransaction(&tx, node.privateKey)
+    err := bc.submitTransaction(tx)
+    if err != nil {
+        fmt.Printf("Transaction rejected: %v\n", err)
+    }
+    return tx, err
+}
+
+// simulateTransfers 随机生成若干笔转账，金额可能超过余额以模拟透支
+func (bc *Blockchain) simulateTransfers(count int) {
+    for i := 0; i < count; i++ {
+        from := &bc.Nodes[rng.Intn(len(bc.Nodes))]
+        to := bc.Nodes[rng.Intn(len(bc.Nodes))]
+        amount := 1 + rng.Intn(bc.State.Balances[from.Address]/4+1)
+        bc.signAndSubmit(from, Transaction{Type: TxTransfer, To: to.Address, Amount: amount})
+    }
+}
@@ -1,71 +1,116 @@
 package main
 
 import (
+    "crypto/ed25519"
     "crypto/sha256"
     "encoding/hex"
+    "flag"
     "fmt"
-    "math/rand"
-    "time"
+    "log"
+)
+
+const (
+    TxTransfer   = "transfer"
+    TxVote       = "vote"
+    TxUnvote     = "unvote"
+    TxCommission = "commission"
+    TxUnjail     = "unjail"
+    TxEvidence   = "evidence"
+    TxProxy      = "proxy"
+    TxPropose    = "propose"
+    TxApprove    = "approve"
 )
 
 type Transaction struct {
-    From      string
-    To        string
-    Amount    int
-    Signature string
+    Type       string
+    From       string
+    To         string
+    Amount     int
+    Candidates []string
+    Evidence   []Block
+    Proposal   *ParamChange
+    Nonce      int64
+    Signature  string
 }
 
 type Vote struct {
-    Candidate      string
-    VoteCount      int
-    AvailableVotes int
+    Voter     string
+    Candidate string
 }
 
 type Node struct {
-    Address        string
-    AvailableVotes int
-    VoteCount      int
-    TokenAmount    int
+    Address     string
+    VoteCount   int
+    TokenAmount int
+    Online      bool
+    model       string
+    privateKey  ed25519.PrivateKey
 }
 
 type Block struct {
-    Index         int
-    Timestamp     int64
-    Transactions  []Transaction
-    Votes         []Vote
-    PreviousHash  string
-    Hash          string
-    Nonce         int
+    Index        int
+    Timestamp    int64
+    Slot         int64
+    Epoch        int
+    Producer     string
+    Signature    string
+    Transactions []Transaction
+    PreviousHash string
+    MerkleRoot   string
+    Hash         string
+    Nonce        int
+    Confirmation *Confirmation
 }
 
 type Blockchain struct {
-    Nodes        []Node
-    Votes        []Vote
-    Blocks       []Block
-    Transactions []Transaction
+    Nodes            []Node
+    Candidates       []string
+    Params           Params
+    Elections        []ElectionResult
+    Blocks           []Block
+    Transactions     []Transaction
+    BFT              bool
+    MissedSlots      []MissedSlot
+    State            *State
+    LastIrreversible int
+    seen             map[slotKey]Block
+    store            *Store
+    cartel           *Cartel
 }
 
+// calculateHash 对规范编码的区块头求哈希，Merkle 根按区块内容重新计算
 func calculateHash(block Block) string {
-    record := string(block.Index) + string(block.Timestamp) + block.PreviousHash + string(block.Nonce)
-    h := sha256.New()
-    h.Write([]byte(record))
-    hashed := h.Sum(nil)
-    return hex.EncodeToString(hashed)
+    block.MerkleRoot = merkleRoot(block.Transactions)
+    return headerHash(block)
 }
 
-func (bc *Blockchain) createGenesisBlock() {
+// headerHash 对区块头中记录的 Merkle 根直接求哈希，不要求附带交易
+func headerHash(block Block) string {
+    hashed := sha256.Sum256(encodeHeader(block))
+    return hex.EncodeToString(hashed[:])
+}
+
+func (bc *Blockchain) createGenesisBlock(timestamp int64) {
     transactions := []Transaction{}
-    votes := []Vote{}
+    for _, node := range bc.Nodes {
+        transactions = append(transactions, Transaction{Type: TxTransfer, To: node.Address, Amount: node.TokenAmount})
+    }
+    transactions = append(transactions, bc.Transactions...)
+    bc.Transactions = nil
     genesisBlock := Block{
         Index:        0,
-        Timestamp:    time.Now().Unix(),
+        Timestamp:    timestamp,
         Transactions: transactions,
-        Votes:        votes,
         PreviousHash: "",
         Nonce:        0,
     }
+    genesisBlock.MerkleRoot = merkleRoot(genesisBlock.Transactions)
     genesisBlock.Hash = calculateHash(genesisBlock)
     bc.Blocks = append(bc.Blocks, genesisBlock)
+    bc.State = newState(bc.Candidates, bc.Params)
+    if err := bc.State.applyGenesis(genesisBlock); err != nil {
+        panic(err)
+    }
     fmt.Println("Genesis Block created:", genesisBlock.Hash)
 }
 
@@ -73,45 +118,43 @@ func (bc *Blockchain) addNode(node Node) {
     bc.Nodes = append(bc.Nodes, node)
 }
 
-func (bc *Blockchain) addVote(vote Vote) {
-    bc.Votes = append(bc.Votes, vote)
+func (bc *Blockchain) registerCandidate(address string) {
+    bc.Candidates = append(bc.Candidates, address)
 }
 
-func initializeNodesAndVotes(bc *Blockchain) {
-    rand.Seed(time.Now().UnixNano())
-    totalTokens := 10000
-    for i := 0; i < 100; i++ {
-        tokenAmount := 1 + rand.Intn(totalTokens/10)
-        totalTokens -= tokenAmount
-        nodeAddress := calculateHash(Block{Index: i})
+// initializeNodes 按模拟配置生成节点，密钥由种子确定，前 Candidates 个节点登记为候选人
+func initializeNodes(bc *Blockchain, config SimConfig) {
+    models := config.assignModels()
+    for i, tokenAmount := range config.tokenAmounts() {
+        nodeAddress, privateKey := keyFromSeed(fmt.Sprintf("sim-%d-%d", config.Seed, i))
         node := Node{
             Address:     nodeAddress,
             TokenAmount: tokenAmount,
+            Online:      true,
+            model:       models[i],
+            privateKey:  privateKey,
         }
         bc.addNode(node)
-        vote := Vote{
-            Candidate:      nodeAddress,
-            VoteCount:      tokenAmount,
-            AvailableVotes: tokenAmount,
+        if i < config.Candidates {
+            bc.registerCandidate(nodeAddress)
         }
-        bc.addVote(vote)
-        fmt.Printf("Node %d added: %s, Token Amount: %d\n", i+1, node.Address, node.TokenAmount)
+        fmt.Printf("Node %d added: %s, Token Amount: %d, Model: %s\n", i+1, node.Address, node.TokenAmount, node.model)
     }
 }
 
-func (n *Node) vote(vote *Vote) {
-    vote.VoteCount++
-    n.AvailableVotes--
-}
-
+// simulateVoting 每个持币人在创世区块中质押部分代币并按各自的投票模型投票，这是各账户的第一笔交易
 func simulateVoting(bc *Blockchain) {
-    rand.Seed(time.Now().UnixNano())
     for i := range bc.Nodes {
-        numVotes := bc.Nodes[i].TokenAmount
-        for j := 0; j < numVotes; j++ {
-            candidateIndex := rand.Intn(len(bc.Votes))
-            bc.Nodes[i].vote(&bc.Votes[candidateIndex])
+        node := &bc.Nodes[i]
+        tx := Transaction{
+            Type:       TxVote,
+            From:       node.Address,
+            Amount:     1 + rng.Intn(node.TokenAmount),
+            Candidates: bc.approvalsFor(node),
+            Nonce:      1,
         }
+        signTransaction(&tx, node.privateKey)
+        bc.Transactions = append(bc.Transactions, tx)
     }
 }
 
@@ -136,48 +179,89 @@ func printTopNodes(nodes []Node, top int) {
 }
 
 func (bc *Blockchain) addBlock(block Block) {
-    block.Hash = calculateHash(block)
     bc.Blocks = append(bc.Blocks, block)
     fmt.Printf("Block %d added with hash: %s\n", block.Index, block.Hash)
+    if err := bc.persist(); err != nil {
+        log.Printf("failed to persist block %d: %v", block.Index, err)
+    }
 }
 
-func (bc *Blockchain) validate() bool {
-    for i := 1; i < len(bc.Blocks); i++ {
-        if bc.Blocks[i].PreviousHash != bc.Blocks[i-1].Hash {
-            return false
+// replay 从创世区块开始逐块校验并重建链上状态，返回最终状态和推进到链尾的调度器
+func replay(blocks []Block, candidates []string, params Params) (*State, *Scheduler, error) {
+    if len(blocks) == 0 {
+        return nil, nil, fmt.Errorf("no genesis block")
+    }
+    state := newState(candidates, params)
+    if err := state.applyGenesis(blocks[0]); err != nil {
+        return nil, nil, fmt.Errorf("genesis block invalid: %w", err)
+    }
+    scheduler, err := newScheduler(blocks[0], state.elect, state.isExcluded, state.paramsAt)
+    if err != nil {
+        return nil, nil, err
+    }
+    if _, err := replayBlocks(state, scheduler, blocks, 1); err != nil {
+        return nil, nil, err
+    }
+    return state, scheduler, nil
+}
+
+// replayBlocks 在位于 blocks[from-1] 的状态和调度器上逐块校验并执行 blocks[from:]，
+// 出错时返回第一个无效区块的高度，此时状态可能已被该区块部分修改
+func replayBlocks(state *State, scheduler *Scheduler, blocks []Block, from int) (int, error) {
+    for i := from; i < len(blocks); i++ {
+        missed, err := scheduler.validateBlock(blocks[i-1], blocks[i])
+        if err != nil {
+            return i, fmt.Errorf("block %d invalid: %w", blocks[i].Index, err)
+        }
+        if err := state.applyBlock(blocks[i], missed); err != nil {
+            return i, fmt.Errorf("block %d invalid: %w", blocks[i].Index, err)
+        }
+        if err := verifyConfirmation(blocks[i], scheduler.activeDelegates()); err != nil {
+            return i, fmt.Errorf("block %d invalid: %w", blocks[i].Index, err)
         }
     }
+    return len(blocks), nil
+}
+
+func (bc *Blockchain) validate() bool {
+    if _, _, err := replay(bc.Blocks, bc.Candidates, bc.Params); err != nil {
+        fmt.Printf("Blockchain invalid: %v\n", err)
+        return false
+    }
     return true
 }
 
 func main() {
-    blockchain := Blockchain{}
-    blockchain.createGenesisBlock()
-    initializeNodesAndVotes(&blockchain)
-    simulateVoting(&blockchain)
-    sortedNodes := sortNodesByVoteCount(blockchain.Nodes)
-    printTopNodes(sortedNodes, 30)
-
-    newBlock1 := Block{
-        Index:        1,
-        Timestamp:    time.Now().Unix(),
-        Transactions: []Transaction{},
-        Votes:        blockchain.Votes,
-        PreviousHash: blockchain.Blocks[len(blockchain.Blocks)-1].Hash,
-        Nonce:        0,
-    }
-    blockchain.addBlock(newBlock1)
+    bft := flag.Bool("bft", false, "run a prepare/commit confirmation among delegates on every block for one-block finality")
+    network := flag.String("network", "", "network config file; run as one node of a multi-process network instead of the simulation")
+    nodeID := flag.String("node", "", "id of this node in the network config")
+    apiAddr := flag.String("api", "", "serve the HTTP API on this address when running as a network node")
+    genesisTime := flag.Int64("genesis-time", 0, "override the genesis time in the network config, in unix seconds")
+    sim := flag.String("sim", "", "simulation config file; defaults to 100 nodes and 21 delegates over 3 epochs")
+    data := flag.String("data", "", "directory to persist blocks and state snapshots in; a network node restores its chain from it on startup")
+    flag.Parse()
 
-    newBlock2 := Block{
-        Index:        2,
-        Timestamp:    time.Now().Unix(),
-        Transactions: []Transaction{},
-        Votes:        blockchain.Votes,
-        PreviousHash: blockchain.Blocks[len(blockchain.Blocks)-1].Hash,
-        Nonce:        0,
+    if *network != "" {
+        config, err := loadNetworkConfig(*network, *genesisTime)
+        if err != nil {
+            log.Fatal(err)
+        }
+        if err := runNetworkNode(config, *nodeID, *apiAddr, *data, *bft); err != nil {
+            log.Fatal(err)
+        }
+        return
     }
-    blockchain.addBlock(newBlock2)
 
-    isValid := blockchain.validate()
-    fmt.Printf("Blockchain valid: %t\n", isValid)
+    config := defaultSimConfig()
+    if *sim != "" {
+        var err error
+        if config, err = loadSimConfig(*sim); err != nil {
+            log.Fatal(err)
+        }
+    }
+    if config.Collusion != nil {
+        runCollusionStudy(config, *bft, *data)
+        return
+    }
+    runSimulation(config, *bft, *data)
 }
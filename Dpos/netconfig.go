@@ -0,0 +1,143 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "os"
+)
+
+// NetworkConfig 多进程网络的配置，所有节点读取同一份配置，据此得到相同的密钥、候选人和创世区块
+type NetworkConfig struct {
+    GenesisTime   int64        `json:"genesisTime"`
+    DelegateCount int          `json:"delegateCount"`
+    EpochBlocks   int          `json:"epochBlocks"`
+    MaxMisses     int          `json:"maxConsecutiveMisses"`
+    SlashPercent  int          `json:"slashPercent"`
+    VoterSlash    int          `json:"voterSlashPercent"`
+    Nodes         []PeerConfig `json:"nodes"`
+}
+
+// PeerConfig 网络中的一个节点：监听地址、密钥种子和创世区块中的分配、质押和投票
+type PeerConfig struct {
+    ID         string   `json:"id"`
+    Addr       string   `json:"addr"`
+    Seed       string   `json:"seed"`
+    Candidate  bool     `json:"candidate"`
+    Tokens     int      `json:"tokens"`
+    Stake      int      `json:"stake"`
+    Approvals  []string `json:"approvals"`
+    Commission int      `json:"commission"`
+}
+
+// loadNetworkConfig 读取并校验网络配置，Approvals 填写候选人节点的 ID；genesisTime 非0时覆盖配置中的创世时间
+func loadNetworkConfig(path string, genesisTime int64) (*NetworkConfig, error) {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+    config := &NetworkConfig{
+        DelegateCount: defaultDelegateCount,
+        MaxMisses:     defaultMaxConsecutiveMisses,
+        SlashPercent:  defaultSlashPercent,
+        VoterSlash:    defaultVoterSlashPercent,
+    }
+    if err := json.Unmarshal(data, config); err != nil {
+        return nil, fmt.Errorf("parse %s: %w", path, err)
+    }
+    if genesisTime != 0 {
+        config.GenesisTime = genesisTime
+    }
+    if config.GenesisTime <= 0 {
+        return nil, fmt.Errorf("genesisTime must be a positive unix time; set it in %s or pass the same -genesis-time to every node", path)
+    }
+    if config.DelegateCount <= 0 {
+        return nil, fmt.Errorf("delegateCount must be positive")
+    }
+    if config.EpochBlocks < 0 {
+        return nil, fmt.Errorf("epochBlocks must not be negative")
+    }
+    if config.MaxMisses <= 0 {
+        return nil, fmt.Errorf("maxConsecutiveMisses must be positive")
+    }
+    if config.SlashPercent < 0 || config.SlashPercent > 100 || config.VoterSlash < 0 || config.VoterSlash > 100 {
+        return nil, fmt.Errorf("slashPercent and voterSlashPercent must be 0 to 100")
+    }
+    peers := make(map[string]PeerConfig, len(config.Nodes))
+    for _, peer := range config.Nodes {
+        if peer.ID == "" || peer.Addr == "" || peer.Seed == "" {
+            return nil, fmt.Errorf("node %q needs an id, addr and seed", peer.ID)
+        }
+        if _, ok := peers[peer.ID]; ok {
+            return nil, fmt.Errorf("duplicate node id %s", peer.ID)
+        }
+        if peer.Tokens <= 0 || peer.Stake < 0 || peer.Stake > peer.Tokens {
+            return nil, fmt.Errorf("node %s: stake %d must be within tokens %d", peer.ID, peer.Stake, peer.Tokens)
+        }
+        if peer.Commission < 0 || peer.Commission > maxCommission {
+            return nil, fmt.Errorf("node %s: commission %d must be 0 to %d", peer.ID, peer.Commission, maxCommission)
+        }
+        peers[peer.ID] = peer
+    }
+    voted := false
+    for _, peer := range config.Nodes {
+        for _, id := range peer.Approvals {
+            if !peers[id].Candidate {
+                return nil, fmt.Errorf("node %s approves %s, which is not a candidate", peer.ID, id)
+            }
+        }
+        voted = voted || peer.Stake > 0 && len(peer.Approvals) > 0
+    }
+    if !voted {
+        return nil, fmt.Errorf("no node stakes for a candidate, so the genesis election has no delegates")
+    }
+    return config, nil
+}
+
+func (c *NetworkConfig) peer(id string) (PeerConfig, bool) {
+    for _, peer := range c.Nodes {
+        if peer.ID == id {
+            return peer, true
+        }
+    }
+    return PeerConfig{}, false
+}
+
+// newBlockchain 按配置登记节点和候选人，并生成确定性的创世区块：
+// 每个节点依次提交质押投票和佣金设置交易，ed25519 签名本身是确定性的
+func (c *NetworkConfig) newBlockchain() *Blockchain {
+    bc := &Blockchain{Params: defaultParams(c.DelegateCount)}
+    bc.Params.EpochBlocks = c.EpochBlocks
+    bc.Params.MaxConsecutiveMisses = c.MaxMisses
+    bc.Params.SlashPercent = c.SlashPercent
+    bc.Params.VoterSlashPercent = c.VoterSlash
+    addresses := make(map[string]string, len(c.Nodes))
+    for _, peer := range c.Nodes {
+        address, privateKey := keyFromSeed(peer.Seed)
+        addresses[peer.ID] = address
+        bc.addNode(Node{Address: address, TokenAmount: peer.Tokens, Online: true, privateKey: privateKey})
+        if peer.Candidate {
+            bc.registerCandidate(address)
+        }
+    }
+    for i, peer := range c.Nodes {
+        node := &bc.Nodes[i]
+        var nonce int64
+        if peer.Stake > 0 && len(peer.Approvals) > 0 {
+            nonce++
+            tx := Transaction{Type: TxVote, From: node.Address, Amount: peer.Stake, Nonce: nonce}
+            for _, id := range peer.Approvals {
+                tx.Candidates = append(tx.Candidates, addresses[id])
+            }
+            signTransaction(&tx, node.privateKey)
+            bc.Transactions = append(bc.Transactions, tx)
+        }
+        if peer.Candidate {
+            nonce++
+            tx := Transaction{Type: TxCommission, From: node.Address, Amount: peer.Commission, Nonce: nonce}
+            signTransaction(&tx, node.privateKey)
+            bc.Transactions = append(bc.Transactions, tx)
+        }
+    }
+    bc.createGenesisBlock(c.GenesisTime)
+    return bc
+}
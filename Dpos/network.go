@@ -0,0 +1,370 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "log"
+    "net"
+    "sync"
+    "time"
+)
+
+const (
+    msgTransaction = "tx"
+    msgBlock       = "block"
+    msgGetBlocks   = "getblocks"
+    msgBlocks      = "blocks"
+    msgVote        = "vote"
+
+    dialTimeout  = time.Second
+    tickInterval = time.Second
+    transferRate = 0.3 // 每个时隙本节点发起一笔随机转账的概率
+)
+
+var errKnownTransaction = errors.New("transaction already known")
+
+// Message 节点之间传递的消息，每条消息单独建立一次 TCP 连接发送
+type Message struct {
+    Type        string
+    From        string
+    Transaction *Transaction `json:",omitempty"`
+    Block       *Block       `json:",omitempty"`
+    Blocks      []Block      `json:",omitempty"`
+    Vote        *PhaseVote   `json:",omitempty"`
+}
+
+// NetworkNode 以独立进程运行的 DPoS 节点：按时隙出块，广播区块和交易，并与对等节点同步链。
+// scheduler 为位于链尾的调度器，新区块只需在它和链上状态的副本上校验。
+// bft 为真时受托人节点对每个链尾区块签名 prepare/commit 投票，rounds 按区块哈希收集投票，prepared 记录本节点在每个高度 prepare 的区块
+type NetworkNode struct {
+    id        string
+    addr      string
+    self      *Node
+    peers     []string
+    bc        *Blockchain
+    scheduler *Scheduler
+    lastSlot  int64
+    seenTx    map[string]bool
+    bft       bool
+    rounds    map[string]*voteRound
+    prepared  map[int]string
+    lock      sync.Mutex
+}
+
+// runNetworkNode 以配置中 id 对应的节点身份运行，配置中的其他节点即对等节点；apiAddr 非空时同时提供 HTTP 接口，
+// dataDir 非空时从中恢复链，并把之后的区块和状态快照写入其中；bft 为真时与其他受托人节点交换投票确认每个区块
+func runNetworkNode(config *NetworkConfig, id, apiAddr, dataDir string, bft bool) error {
+    self, ok := config.peer(id)
+    if !ok {
+        return fmt.Errorf("node %q is not in the network config", id)
+    }
+    bc := config.newBlockchain()
+    var scheduler *Scheduler
+    if dataDir != "" {
+        store, err := openStore(dataDir)
+        if err != nil {
+            return err
+        }
+        if scheduler, err = bc.restore(store); err != nil {
+            return err
+        }
+    } else {
+        state, s, err := replay(bc.Blocks, bc.Candidates, bc.Params)
+        if err != nil {
+            return err
+        }
+        bc.State, scheduler = state, s
+    }
+    address, _ := keyFromSeed(self.Seed)
+    n := &NetworkNode{
+        id:        id,
+        addr:      self.Addr,
+        self:      bc.findNode(address),
+        bc:        bc,
+        scheduler: scheduler,
+        seenTx:    make(map[string]bool),
+        bft:       bft,
+        rounds:    make(map[string]*voteRound),
+        prepared:  make(map[int]string),
+    }
+    for _, peer := range config.Nodes {
+        if peer.ID != id {
+            n.peers = append(n.peers, peer.Addr)
+        }
+    }
+    listener, err := net.Listen("tcp", n.addr)
+    if err != nil {
+        return err
+    }
+    defer listener.Close()
+    fmt.Printf("Node %s (%s) listening on %s with %d peers\n", id, address, n.addr, len(n.peers))
+    if apiAddr != "" {
+        go func() {
+            if err := n.RunAPI(apiAddr); err != nil {
+                log.Println("api error", err)
+            }
+        }()
+    }
+    go n.broadcast(Message{Type: msgGetBlocks})
+    go n.produceLoop()
+    for {
+        conn, err := listener.Accept()
+        if err != nil {
+            return err
+        }
+        go n.handleConn(conn)
+    }
+}
+
+func (n *NetworkNode) handleConn(conn net.Conn) {
+    defer conn.Close()
+    data, err := io.ReadAll(conn)
+    if err != nil {
+        log.Println("read error", err)
+        return
+    }
+    var msg Message
+    if err := json.Unmarshal(data, &msg); err != nil {
+        log.Println("bad message", err)
+        return
+    }
+    switch msg.Type {
+    case msgTransaction:
+        if msg.Transaction != nil {
+            if err := n.handleTransaction(*msg.Transaction, false); err != nil && !errors.Is(err, errKnownTransaction) {
+                log.Println("transaction rejected", err)
+            }
+        }
+    case msgBlock:
+        if msg.Block != nil {
+            n.handleBlock(*msg.Block, msg.From)
+        }
+    case msgGetBlocks:
+        n.lock.Lock()
+        blocks := append([]Block(nil), n.bc.Blocks...)
+        n.lock.Unlock()
+        n.send(msg.From, Message{Type: msgBlocks, Blocks: blocks})
+    case msgBlocks:
+        n.handleBlocks(msg.Blocks, msg.From)
+    case msgVote:
+        if msg.Vote != nil {
+            n.handleVote(*msg.Vote)
+        }
+    }
+}
+
+// send 向 addr 发送一条消息
+func (n *NetworkNode) send(addr string, msg Message) {
+    msg.From = n.addr
+    data, err := json.Marshal(msg)
+    if err != nil {
+        log.Println("encode error", err)
+        return
+    }
+    conn, err := net.DialTimeout("tcp", addr, dialTimeout)
+    if err != nil {
+        log.Println("connect error", err)
+        return
+    }
+    defer conn.Close()
+    if _, err := conn.Write(data); err != nil {
+        log.Println("send error", err)
+    }
+}
+
+// broadcast 向除 except 以外的全部对等节点发送消息
+func (n *NetworkNode) broadcast(msg Message, except ...string) {
+    for _, peer := range n.peers {
+        if len(except) > 0 && peer == except[0] {
+            continue
+        }
+        n.send(peer, msg)
+    }
+}
+
+// handleTransaction 把第一次见到的有效交易放入待打包队列并继续转发。check 为真时先在链上状态的副本上执行交易，
+// 拒绝序号或余额不符等无法生效的交易；对等节点转发的交易可能乱序到达，不做此检查
+func (n *NetworkNode) handleTransaction(tx Transaction, check bool) error {
+    n.lock.Lock()
+    if n.seenTx[tx.Signature] {
+        n.lock.Unlock()
+        return errKnownTransaction
+    }
+    if check {
+        if err := n.bc.checkTransaction(tx); err != nil {
+            n.lock.Unlock()
+            return err
+        }
+    }
+    n.seenTx[tx.Signature] = true
+    err := n.bc.submitTransaction(tx)
+    n.lock.Unlock()
+    if err != nil {
+        return err
+    }
+    go n.broadcast(Message{Type: msgTransaction, Transaction: &tx})
+    return nil
+}
+
+// handleBlock 接受接在链尾的区块并继续转发；区块高于链尾或不接在链尾时向发送方请求整条链。
+// 收到的区块都用于发现同一受托人在同一高度签出的两个区块
+func (n *NetworkNode) handleBlock(block Block, from string) {
+    n.lock.Lock()
+    tip := n.bc.Blocks[len(n.bc.Blocks)-1]
+    if block.Index <= tip.Index {
+        n.reportDoubleSign(block)
+        n.lock.Unlock()
+        return
+    }
+    if block.Index > tip.Index+1 || block.PreviousHash != tip.Hash {
+        n.lock.Unlock()
+        n.send(from, Message{Type: msgGetBlocks})
+        return
+    }
+    err := n.extend([]Block{block})
+    if err == nil {
+        n.reportDoubleSign(block)
+        n.startRound(block)
+    }
+    n.lock.Unlock()
+    if err != nil {
+        log.Printf("block %d from %s rejected: %v", block.Index, from, err)
+        return
+    }
+    fmt.Printf("Block %d accepted from %s: %s\n", block.Index, block.Producer, block.Hash)
+    n.broadcast(Message{Type: msgBlock, Block: &block}, from)
+}
+
+// reportDoubleSign 记录出块人签名有效的区块头，与链上或之前收到的同一出块人同一时隙的区块冲突时提交并广播证据，调用时需持有锁
+func (n *NetworkNode) reportDoubleSign(block Block) {
+    if headerHash(block) != block.Hash || !verifyBlockSignature(block) {
+        return
+    }
+    if onChain, ok := n.bc.blockAtSlot(block.Slot); ok {
+        n.bc.observeBlock(onChain)
+    }
+    evidence := n.bc.observeBlock(block)
+    if evidence == nil || n.bc.State.Slashed[block.Producer] {
+        return
+    }
+    fmt.Printf("Delegate %s signed two blocks in slot %d\n", block.Producer, block.Slot)
+    tx, err := n.bc.signAndSubmit(n.self, Transaction{Type: TxEvidence, Evidence: evidence})
+    if err != nil {
+        return
+    }
+    n.seenTx[tx.Signature] = true
+    go n.broadcast(Message{Type: msgTransaction, Transaction: &tx})
+}
+
+// handleBlocks 同步对等节点的整条链，只有更长且不回滚不可逆区块的链才会被采用
+func (n *NetworkNode) handleBlocks(blocks []Block, from string) {
+    n.lock.Lock()
+    defer n.lock.Unlock()
+    if len(blocks) <= len(n.bc.Blocks) {
+        return
+    }
+    if err := n.adopt(blocks); err != nil {
+        log.Printf("chain from %s rejected: %v", from, err)
+        return
+    }
+    fmt.Printf("Synced to block %d from %s\n", len(blocks)-1, from)
+}
+
+// extend 在链尾之后接上 blocks，只校验和执行这些新区块，调用时需持有锁
+func (n *NetworkNode) extend(blocks []Block) error {
+    s, err := n.bc.extendChain(n.scheduler, blocks)
+    if err != nil {
+        return err
+    }
+    n.accept(s)
+    return nil
+}
+
+// adopt 切换到对等节点更长的链：包含本地链尾时只执行链尾之后的区块，分叉到其他分支时才从创世区块重放，调用时需持有锁
+func (n *NetworkNode) adopt(blocks []Block) error {
+    tip := len(n.bc.Blocks) - 1
+    if blocks[tip].Hash == n.bc.Blocks[tip].Hash {
+        return n.extend(blocks[tip+1:])
+    }
+    s, err := n.bc.replaceChain(blocks)
+    if err != nil {
+        return err
+    }
+    n.accept(s)
+    return nil
+}
+
+// accept 记下新链尾的调度器，并清理待打包队列中已上链或序号已用过的交易
+func (n *NetworkNode) accept(s *Scheduler) {
+    n.scheduler = s
+    pending := n.bc.Transactions[:0]
+    for _, tx := range n.bc.Transactions {
+        if tx.Nonce > n.bc.State.Nonces[tx.From] {
+            pending = append(pending, tx)
+        }
+    }
+    n.bc.Transactions = pending
+}
+
+// produceLoop 每进入一个新时隙，在链尾调度器的副本上推进到该时隙，轮到本节点时出块并广播
+func (n *NetworkNode) produceLoop() {
+    ticker := time.NewTicker(tickInterval)
+    defer ticker.Stop()
+    for range ticker.C {
+        if block := n.tick(); block != nil {
+            n.broadcast(Message{Type: msgBlock, Block: block})
+        }
+    }
+}
+
+func (n *NetworkNode) tick() *Block {
+    n.lock.Lock()
+    defer n.lock.Unlock()
+    tip := n.bc.Blocks[len(n.bc.Blocks)-1]
+    s := n.scheduler.clone(n.bc.State)
+    slot := s.slotAt(time.Now().Unix())
+    if slot <= n.lastSlot || slot <= tip.Slot {
+        return nil
+    }
+    n.lastSlot = slot
+    n.submitLocal()
+    missed := s.skipped(tip, slot)
+    s.advance(slot, tip)
+    if s.producerAt(slot) != n.self.Address {
+        return nil
+    }
+    block := n.bc.produceBlock(n.self, slot, s.Epoch, s.slotTime(slot), missed)
+    s.bind(n.bc.State)
+    n.scheduler = s
+    n.bc.observeBlock(block)
+    n.bc.updateLastIrreversible(s.activeDelegates())
+    if err := n.bc.persist(); err != nil {
+        log.Printf("failed to persist block %d: %v", block.Index, err)
+    }
+    n.startRound(block)
+    return &block
+}
+
+// submitLocal 本节点被监禁时申请恢复，并按一定概率向随机对等节点转账，新交易广播给全网
+func (n *NetworkNode) submitLocal() {
+    var txs []Transaction
+    if n.bc.State.Jailed[n.self.Address] && !n.bc.hasPending(n.self.Address, TxUnjail) {
+        if tx, err := n.bc.signAndSubmit(n.self, Transaction{Type: TxUnjail}); err == nil {
+            txs = append(txs, tx)
+        }
+    }
+    if balance := n.bc.State.Balances[n.self.Address]; balance > 0 && rng.Float64() < transferRate {
+        to := n.bc.Nodes[rng.Intn(len(n.bc.Nodes))]
+        amount := 1 + rng.Intn(balance/4+1)
+        if tx, err := n.bc.signAndSubmit(n.self, Transaction{Type: TxTransfer, To: to.Address, Amount: amount}); err == nil {
+            txs = append(txs, tx)
+        }
+    }
+    for _, tx := range txs {
+        n.seenTx[tx.Signature] = true
+        tx := tx
+        go n.broadcast(Message{Type: msgTransaction, Transaction: &tx})
+    }
+}
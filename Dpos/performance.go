@@ -0,0 +1,119 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "sort"
+)
+
+const defaultMaxConsecutiveMisses = 3
+
+var errNotJailed = errors.New("delegate is not jailed")
+
+// DelegateStats 受托人的出块记录
+type DelegateStats struct {
+    Scheduled         int
+    Produced          int
+    ConsecutiveMisses int
+}
+
+// reliability 已出块时隙占排定时隙的比例
+func (d DelegateStats) reliability() float64 {
+    if d.Scheduled == 0 {
+        return 0
+    }
+    return float64(d.Produced) / float64(d.Scheduled)
+}
+
+// recordSlots 记录区块之前错过的时隙和本区块的出块，返回因此被监禁的受托人
+func (s *State) recordSlots(missed []string, producer string) []string {
+    var jailed []string
+    for _, delegate := range missed {
+        stats := s.Performance[delegate]
+        stats.Scheduled++
+        stats.ConsecutiveMisses++
+        s.Performance[delegate] = stats
+        if stats.ConsecutiveMisses >= s.Params.MaxConsecutiveMisses && !s.Jailed[delegate] {
+            s.Jailed[delegate] = true
+            jailed = append(jailed, delegate)
+        }
+    }
+    stats := s.Performance[producer]
+    stats.Scheduled++
+    stats.Produced++
+    stats.ConsecutiveMisses = 0
+    s.Performance[producer] = stats
+    return jailed
+}
+
+// applyUnjail 被监禁的受托人申请恢复，下一轮起重新参与出块和选举
+func (s *State) applyUnjail(tx Transaction) error {
+    if s.Slashed[tx.From] {
+        return fmt.Errorf("%w: %s was slashed", errNotJailed, tx.From)
+    }
+    if !s.Jailed[tx.From] {
+        return fmt.Errorf("%w: %s", errNotJailed, tx.From)
+    }
+    delete(s.Jailed, tx.From)
+    stats := s.Performance[tx.From]
+    stats.ConsecutiveMisses = 0
+    s.Performance[tx.From] = stats
+    return nil
+}
+
+// missedSince 记录在 slot 之后错过的时隙的排定出块人
+func (bc *Blockchain) missedSince(slot int64) []string {
+    var missed []string
+    for _, m := range bc.MissedSlots {
+        if m.Slot > slot {
+            missed = append(missed, m.Producer)
+        }
+    }
+    return missed
+}
+
+// simulateUnjail 在线的被监禁节点提交恢复交易
+func (bc *Blockchain) simulateUnjail() {
+    for i := range bc.Nodes {
+        node := &bc.Nodes[i]
+        if node.Online && bc.State.Jailed[node.Address] && !bc.hasPending(node.Address, TxUnjail) {
+            bc.signAndSubmit(node, Transaction{Type: TxUnjail})
+        }
+    }
+}
+
+// hasPending 账户是否已有该类型的交易在等待打包
+func (bc *Blockchain) hasPending(address, txType string) bool {
+    for _, tx := range bc.Transactions {
+        if tx.From == address && tx.Type == txType {
+            return true
+        }
+    }
+    return false
+}
+
+// printReliability 打印受托人的出块率，按可靠性从低到高排列
+func printReliability(s *State) {
+    delegates := make([]string, 0, len(s.Performance))
+    for delegate := range s.Performance {
+        delegates = append(delegates, delegate)
+    }
+    sort.Slice(delegates, func(i, j int) bool {
+        ri, rj := s.Performance[delegates[i]].reliability(), s.Performance[delegates[j]].reliability()
+        if ri != rj {
+            return ri < rj
+        }
+        return delegates[i] < delegates[j]
+    })
+    fmt.Println("Delegate reliability (produced/scheduled):")
+    for _, delegate := range delegates {
+        stats := s.Performance[delegate]
+        status := ""
+        if s.Slashed[delegate] {
+            status = " [slashed]"
+        } else if s.Jailed[delegate] {
+            status = " [jailed]"
+        }
+        fmt.Printf("%s - %d/%d (%.1f%%)%s\n", delegate, stats.Produced, stats.Scheduled, 100*stats.reliability(), status)
+    }
+}
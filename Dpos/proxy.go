@@ -0,0 +1,134 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "sort"
+)
+
+const proxyChangesPerSlot = 1
+
+var errBadProxy = errors.New("invalid proxy")
+
+// applyProxy 把账户的投票权委托给 To 代理，To 为空则取消代理；可同时追加质押 Amount 个代币。
+// 代理不能再委托他人，已经是代理的账户也不能委托，变更从下一届开始生效
+func (s *State) applyProxy(tx Transaction) error {
+    if tx.Amount < 0 {
+        return errBadAmount
+    }
+    next := s.nextProxies()
+    if tx.To != "" {
+        if tx.To == tx.From {
+            return fmt.Errorf("%w: %s cannot proxy to itself", errBadProxy, tx.From)
+        }
+        if next[tx.To] != "" {
+            return fmt.Errorf("%w: %s already proxies to %s", errBadProxy, tx.To, next[tx.To])
+        }
+        if isProxy(next, tx.From) {
+            return fmt.Errorf("%w: %s is a proxy for other accounts", errBadProxy, tx.From)
+        }
+    }
+    if s.Balances[tx.From] < tx.Amount {
+        return fmt.Errorf("%w: %s has %d, needs %d", errOverdraft, tx.From, s.Balances[tx.From], tx.Amount)
+    }
+    s.Balances[tx.From] -= tx.Amount
+    s.Staked[tx.From] += tx.Amount
+    s.PendingProxies[tx.From] = tx.To
+    return nil
+}
+
+// promoteProxies 新一届开始时，上一届提交的代理变更生效
+func (s *State) promoteProxies() {
+    for account, proxy := range s.PendingProxies {
+        if proxy == "" {
+            delete(s.Proxies, account)
+        } else {
+            s.Proxies[account] = proxy
+        }
+    }
+    s.PendingProxies = make(map[string]string)
+}
+
+// nextProxies 计入尚未生效的变更后的代理关系，下一届的选举按它统计
+func (s *State) nextProxies() map[string]string {
+    next := make(map[string]string, len(s.Proxies)+len(s.PendingProxies))
+    for account, proxy := range s.Proxies {
+        next[account] = proxy
+    }
+    for account, proxy := range s.PendingProxies {
+        if proxy == "" {
+            delete(next, account)
+        } else {
+            next[account] = proxy
+        }
+    }
+    return next
+}
+
+// votingWeights 按代理关系汇总投票权重：委托了代理的账户，其质押量计入代理，自身的赞成票不再有权重
+func (s *State) votingWeights(proxies map[string]string) map[string]int {
+    weights := make(map[string]int, len(s.Staked))
+    for account, staked := range s.Staked {
+        if proxy := proxies[account]; proxy != "" {
+            weights[proxy] += staked
+        } else {
+            weights[account] += staked
+        }
+    }
+    return weights
+}
+
+// tally 按下一届生效的代理关系统计 candidates 的得票
+func (s *State) tally(candidates []string) []CandidateTally {
+    return tallyVotes(s.votingWeights(s.nextProxies()), candidates, s.votes())
+}
+
+// backers 按代理关系找出支持 candidate 的质押账户，委托了代理的账户随代理的投票，按地址排序
+func (s *State) backers(candidate string, proxies map[string]string) []string {
+    approves := make(map[string]bool)
+    for _, vote := range s.votes() {
+        if vote.Candidate == candidate {
+            approves[vote.Voter] = true
+        }
+    }
+    var backers []string
+    for account := range s.Staked {
+        voter := account
+        if proxy := proxies[account]; proxy != "" {
+            voter = proxy
+        }
+        if approves[voter] {
+            backers = append(backers, account)
+        }
+    }
+    sort.Strings(backers)
+    return backers
+}
+
+// simulateProxyChanges 随机让若干持币人委托给另一个没有委托他人的账户，或取消委托
+func (bc *Blockchain) simulateProxyChanges(count int) {
+    next := bc.State.nextProxies()
+    for i := 0; i < count; i++ {
+        node := &bc.Nodes[rng.Intn(len(bc.Nodes))]
+        if next[node.Address] != "" && rng.Intn(2) == 0 {
+            bc.signAndSubmit(node, Transaction{Type: TxProxy})
+            continue
+        }
+        proxy := bc.Nodes[rng.Intn(len(bc.Nodes))].Address
+        if proxy == node.Address || next[proxy] != "" || isProxy(next, node.Address) {
+            continue
+        }
+        bc.signAndSubmit(node, Transaction{Type: TxProxy, To: proxy})
+        next[node.Address] = proxy
+    }
+}
+
+// isProxy address 是否为其他账户的代理
+func isProxy(proxies map[string]string, address string) bool {
+    for _, proxy := range proxies {
+        if proxy == address {
+            return true
+        }
+    }
+    return false
+}
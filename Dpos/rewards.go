@@ -0,0 +1,158 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "sort"
+)
+
+const (
+    defaultBlockReward = 100 // 创世时每个区块的奖励
+    defaultCommission  = 10  // 未设置佣金比例的受托人默认抽取的百分比
+    maxCommission      = 100
+)
+
+// 出块奖励中投票人部分的分配规则
+const (
+    RewardProportional = "proportional" // 按质押量分给支持出块受托人的账户
+    RewardEqual        = "equal"        // 平均分给每个支持出块受托人的账户
+    RewardNone         = "none"         // 不与投票人分享，全部归出块受托人
+)
+
+var errBadCommission = errors.New("invalid commission")
+
+// Earnings 账户在一届中获得的奖励：作为受托人抽取的佣金，以及作为投票人分得的部分
+type Earnings struct {
+    Commission  int
+    VoterReward int
+}
+
+func (e Earnings) total() int {
+    return e.Commission + e.VoterReward
+}
+
+// applyCommission 候选人将自己的佣金比例设为 Amount 个百分点
+func (s *State) applyCommission(tx Transaction) error {
+    if !s.Candidates[tx.From] {
+        return fmt.Errorf("%w: %s is not a candidate", errBadCommission, tx.From)
+    }
+    if tx.Amount < 0 || tx.Amount > maxCommission {
+        return fmt.Errorf("%w: %d%% is outside 0 to %d%%", errBadCommission, tx.Amount, maxCommission)
+    }
+    s.Commission[tx.From] = tx.Amount
+    return nil
+}
+
+// commissionOf 受托人当前的佣金比例
+func (s *State) commissionOf(delegate string) int {
+    if commission, ok := s.Commission[delegate]; ok {
+        return commission
+    }
+    return defaultCommission
+}
+
+// distributeReward 出块受托人先按佣金比例抽取奖励，其余按分配规则分给支持该受托人的账户，
+// 包括通过代理支持的账户；整除剩下的零头和无人投票时的剩余部分都归受托人
+func (s *State) distributeReward(producer string, epoch int) {
+    blockReward := s.Params.BlockReward
+    shared := blockReward - blockReward*s.commissionOf(producer)/100
+    if s.Params.RewardSharing == RewardNone {
+        shared = 0
+    }
+    backers := s.backers(producer, s.Proxies)
+    totalStake := 0
+    for _, backer := range backers {
+        totalStake += s.Staked[backer]
+    }
+    paid := 0
+    if totalStake > 0 {
+        for _, backer := range backers {
+            reward := shared * s.Staked[backer] / totalStake
+            if s.Params.RewardSharing == RewardEqual {
+                reward = shared / len(backers)
+            }
+            if reward == 0 {
+                continue
+            }
+            s.Balances[backer] += reward
+            s.addEarnings(epoch, backer, Earnings{VoterReward: reward})
+            paid += reward
+        }
+    }
+    s.Balances[producer] += blockReward - paid
+    s.addEarnings(epoch, producer, Earnings{Commission: blockReward - paid})
+}
+
+func (s *State) addEarnings(epoch int, address string, earned Earnings) {
+    if s.Earnings[epoch] == nil {
+        s.Earnings[epoch] = make(map[string]Earnings)
+    }
+    e := s.Earnings[epoch][address]
+    e.Commission += earned.Commission
+    e.VoterReward += earned.VoterReward
+    s.Earnings[epoch][address] = e
+}
+
+// earningsReport 汇总每个账户各届的奖励，按总奖励从高到低排列
+func (s *State) earningsReport() ([]string, []int, map[string]map[int]Earnings) {
+    epochs := make([]int, 0, len(s.Earnings))
+    byAccount := make(map[string]map[int]Earnings)
+    totals := make(map[string]int)
+    for epoch, accounts := range s.Earnings {
+        epochs = append(epochs, epoch)
+        for address, earned := range accounts {
+            if byAccount[address] == nil {
+                byAccount[address] = make(map[int]Earnings)
+            }
+            byAccount[address][epoch] = earned
+            totals[address] += earned.total()
+        }
+    }
+    sort.Ints(epochs)
+    accounts := make([]string, 0, len(byAccount))
+    for address := range byAccount {
+        accounts = append(accounts, address)
+    }
+    sort.Slice(accounts, func(i, j int) bool {
+        if totals[accounts[i]] != totals[accounts[j]] {
+            return totals[accounts[i]] > totals[accounts[j]]
+        }
+        return accounts[i] < accounts[j]
+    })
+    return accounts, epochs, byAccount
+}
+
+// printEarnings 打印奖励最多的 top 个账户在各届的佣金和投票奖励
+func printEarnings(s *State, top int) {
+    accounts, epochs, byAccount := s.earningsReport()
+    fmt.Printf("Top %d accounts by rewards (commission/voter reward per epoch):\n", top)
+    for i := 0; i < top && i < len(accounts); i++ {
+        address := accounts[i]
+        fmt.Printf("%d. %s", i+1, address)
+        total := 0
+        for _, epoch := range epochs {
+            earned := byAccount[address][epoch]
+            fmt.Printf(" | epoch %d: %d/%d", epoch, earned.Commission, earned.VoterReward)
+            total += earned.total()
+        }
+        fmt.Printf(" | total: %d\n", total)
+    }
+}
+
+// simulateCommissions 每个候选人在创世区块中设置随机的佣金比例，卡特尔成员抽取全部奖励再私下返还，这是各账户的第二笔交易
+func simulateCommissions(bc *Blockchain) {
+    for _, candidate := range bc.Candidates {
+        node := bc.findNode(candidate)
+        tx := Transaction{
+            Type:   TxCommission,
+            From:   node.Address,
+            Amount: rng.Intn(maxCommission/2 + 1),
+            Nonce:  2,
+        }
+        if node.model == modelCartel {
+            tx.Amount = maxCommission
+        }
+        signTransaction(&tx, node.privateKey)
+        bc.Transactions = append(bc.Transactions, tx)
+    }
+}
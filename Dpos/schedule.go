@@ -0,0 +1,284 @@
+package main
+
+import (
+    "crypto/sha256"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "log"
+    "strconv"
+)
+
+const (
+    defaultSlotInterval = 3 // 创世时每个出块时隙的秒数
+    defaultEpochRounds  = 3 // 未配置每届区块数时，每届按受托人数出这么多轮
+    outageRate          = 0.05
+    recoverRate         = 0.5
+    transfersPerSlot    = 3
+    voteChangesPerSlot  = 1
+    maxSkippedSlots     = 1000 // 链尾之后只记录最近这么多个空时隙的排定出块人，更早的空时隙不计入出块记录
+)
+
+// Scheduler 将时间划分为固定长度的时隙，时隙0为创世区块。
+// 每一轮由当前受托人各出一个时隙，轮内顺序由上一轮最后一个区块的哈希确定性地打乱；
+// 每出满 EpochBlocks 个区块，在下一轮开始时按新一届生效的参数重新选举受托人；被监禁或罚没的受托人从下一轮起不再排入出块顺序。
+// 时隙长度改变时，以新一届第一个时隙 AnchorSlot 的开始时间 AnchorTime 为起点重新划分时隙
+type Scheduler struct {
+    GenesisTime  int64
+    SlotInterval int64
+    AnchorSlot   int64
+    AnchorTime   int64
+    EpochBlocks  int
+    Epoch        int
+    EpochStart   int
+    Delegates    []string
+    Round        int
+    RoundStart   int64
+    Order        []string
+    elect        func(epoch int) []string
+    excluded     func(address string) bool
+    params       func(epoch int) Params
+}
+
+var errNoDelegates = errors.New("genesis election has no delegates")
+
+// MissedSlot 排定的出块人离线而未出块的时隙
+type MissedSlot struct {
+    Slot     int64
+    Producer string
+}
+
+// newScheduler 从创世区块开始第一届第一轮，elect 根据当前链上状态选出某一届的受托人，
+// excluded 查询受托人是否被移出出块顺序，params 查询某一届生效的共识参数。
+// 第一届没有候选人得票时无法排定出块人，返回错误
+func newScheduler(genesis Block, elect func(epoch int) []string, excluded func(address string) bool, params func(epoch int) Params) (*Scheduler, error) {
+    s := &Scheduler{
+        GenesisTime:  genesis.Timestamp,
+        SlotInterval: params(1).SlotInterval,
+        AnchorTime:   genesis.Timestamp,
+        EpochBlocks:  params(1).epochLength(),
+        Epoch:        1,
+        Delegates:    elect(1),
+        Round:        1,
+        RoundStart:   1,
+        elect:        elect,
+        excluded:     excluded,
+        params:       params,
+    }
+    if len(s.Delegates) == 0 {
+        return nil, errNoDelegates
+    }
+    s.Order = shuffleDelegates(s.activeDelegates(), genesis.Hash)
+    return s, nil
+}
+
+// clone 复制调度器并让副本读取 state，推进副本不影响原调度器
+func (s *Scheduler) clone(state *State) *Scheduler {
+    c := *s
+    c.bind(state)
+    return &c
+}
+
+// activeDelegates 本届仍可出块的受托人；全部被移出时仍由本届全部受托人出块，以免链停止
+func (s *Scheduler) activeDelegates() []string {
+    active := make([]string, 0, len(s.Delegates))
+    for _, delegate := range s.Delegates {
+        if !s.excluded(delegate) {
+            active = append(active, delegate)
+        }
+    }
+    if len(active) == 0 {
+        return append(active, s.Delegates...)
+    }
+    return active
+}
+
+// shuffleDelegates 以 seed 为种子对受托人做确定性的 Fisher-Yates 洗牌
+func shuffleDelegates(delegates []string, seed string) []string {
+    order := append([]string(nil), delegates...)
+    for i := len(order) - 1; i > 0; i-- {
+        h := sha256.Sum256([]byte(seed + ":" + strconv.Itoa(i)))
+        j := int(binary.BigEndian.Uint64(h[:8]) % uint64(i+1))
+        order[i], order[j] = order[j], order[i]
+    }
+    return order
+}
+
+// advance 推进到 slot 所在的轮次，tip 为此时的链尾区块。新一届没有候选人得票时沿用上一届的受托人
+func (s *Scheduler) advance(slot int64, tip Block) {
+    for slot >= s.RoundStart+int64(len(s.Order)) {
+        s.RoundStart += int64(len(s.Order))
+        s.Round++
+        if tip.Index-s.EpochStart >= s.EpochBlocks {
+            s.Epoch++
+            s.EpochStart = tip.Index
+            s.EpochBlocks = s.params(s.Epoch).epochLength()
+            if interval := s.params(s.Epoch).SlotInterval; interval != s.SlotInterval {
+                s.AnchorTime = s.slotTime(s.RoundStart)
+                s.AnchorSlot = s.RoundStart
+                s.SlotInterval = interval
+            }
+            if delegates := s.elect(s.Epoch); len(delegates) > 0 {
+                s.Delegates = delegates
+            }
+        }
+        s.Order = shuffleDelegates(s.activeDelegates(), tip.Hash)
+        // 链尾不变时之后各轮的受托人和出块顺序都与本轮相同，直接跳过这些整轮
+        if rounds := (slot - s.RoundStart) / int64(len(s.Order)); rounds > 0 {
+            s.RoundStart += rounds * int64(len(s.Order))
+            s.Round += int(rounds)
+        }
+    }
+}
+
+// startsEpoch 链尾为 tip 时推进到 slot 是否会开始新一届
+func (s *Scheduler) startsEpoch(slot int64, tip Block) bool {
+    return slot >= s.RoundStart+int64(len(s.Order)) && tip.Index-s.EpochStart >= s.EpochBlocks
+}
+
+// slotAt 时间戳所在的时隙
+func (s *Scheduler) slotAt(timestamp int64) int64 {
+    return s.AnchorSlot + (timestamp-s.AnchorTime)/s.SlotInterval
+}
+
+// slotTime 时隙的开始时间
+func (s *Scheduler) slotTime(slot int64) int64 {
+    return s.AnchorTime + (slot-s.AnchorSlot)*s.SlotInterval
+}
+
+// producerAt 当前轮次内时隙的排定出块人，调用前需先 advance 到该时隙
+func (s *Scheduler) producerAt(slot int64) string {
+    return s.Order[slot-s.RoundStart]
+}
+
+// skipped 调度器位于链尾 tip 的时隙时，推进越过 tip 与 slot 之间的空时隙，返回其中最近 maxSkippedSlots 个时隙的排定出块人
+func (s *Scheduler) skipped(tip Block, slot int64) []string {
+    var missed []string
+    for gap := max(tip.Slot+1, slot-maxSkippedSlots); gap < slot; gap++ {
+        s.advance(gap, tip)
+        missed = append(missed, s.producerAt(gap))
+    }
+    return missed
+}
+
+func (bc *Blockchain) findNode(address string) *Node {
+    for i := range bc.Nodes {
+        if bc.Nodes[i].Address == address {
+            return &bc.Nodes[i]
+        }
+    }
+    return nil
+}
+
+// produceBlock 由出块人打包待确认交易，在指定时隙生成区块并签名；missed 为链尾之后错过的时隙的排定出块人
+func (bc *Blockchain) produceBlock(producer *Node, slot int64, epoch int, timestamp int64, missed []string) Block {
+    prev := bc.Blocks[len(bc.Blocks)-1]
+    state := bc.State.clone()
+    for _, delegate := range state.beginBlock(prev.Index+1, epoch, producer.Address, missed) {
+        fmt.Printf("Delegate %s jailed after %d consecutive missed slots\n", delegate, state.Params.MaxConsecutiveMisses)
+    }
+    var included []Transaction
+    for _, tx := range bc.Transactions {
+        if err := state.applyTransaction(tx); err != nil {
+            fmt.Printf("Dropped transaction from %s: %v\n", tx.From, err)
+            continue
+        }
+        included = append(included, tx)
+    }
+    state.distributeReward(producer.Address, epoch)
+    block := Block{
+        Index:        prev.Index + 1,
+        Timestamp:    timestamp,
+        Slot:         slot,
+        Epoch:        epoch,
+        Producer:     producer.Address,
+        Transactions: included,
+        PreviousHash: prev.Hash,
+    }
+    bc.Transactions = nil
+    bc.State = state
+    bc.syncTokenAmounts()
+    block.MerkleRoot = merkleRoot(block.Transactions)
+    block.Hash = calculateHash(block)
+    signBlock(&block, producer.privateKey)
+    bc.addBlock(block)
+    return block
+}
+
+// validateBlock 校验区块接在 prev 之后、由该时隙的排定受托人出块并签名，调用后调度器推进到该区块的时隙。
+// 返回两个区块之间空缺时隙的排定出块人
+func (s *Scheduler) validateBlock(prev, block Block) ([]string, error) {
+    if block.PreviousHash != prev.Hash {
+        return nil, fmt.Errorf("previous hash mismatch")
+    }
+    if block.Index != prev.Index+1 || block.Slot <= prev.Slot {
+        return nil, fmt.Errorf("index %d or slot %d does not follow block %d at slot %d", block.Index, block.Slot, prev.Index, prev.Slot)
+    }
+    if merkleRoot(block.Transactions) != block.MerkleRoot {
+        return nil, fmt.Errorf("merkle root mismatch")
+    }
+    if calculateHash(block) != block.Hash {
+        return nil, fmt.Errorf("hash mismatch")
+    }
+    missed := s.skipped(prev, block.Slot)
+    s.advance(block.Slot, prev)
+    if block.Timestamp != s.slotTime(block.Slot) {
+        return nil, fmt.Errorf("timestamp %d does not match slot %d", block.Timestamp, block.Slot)
+    }
+    if scheduled := s.producerAt(block.Slot); block.Producer != scheduled {
+        return nil, fmt.Errorf("produced by %s but slot %d is scheduled for %s", block.Producer, block.Slot, scheduled)
+    }
+    if block.Epoch != s.Epoch {
+        return nil, fmt.Errorf("epoch %d does not match scheduled epoch %d", block.Epoch, s.Epoch)
+    }
+    if !verifyBlockSignature(block) {
+        return nil, fmt.Errorf("invalid producer signature")
+    }
+    return missed, nil
+}
+
+// simulateOutages 随机让节点离线或恢复在线
+func (bc *Blockchain) simulateOutages() {
+    for i := range bc.Nodes {
+        if bc.Nodes[i].Online {
+            bc.Nodes[i].Online = rng.Float64() >= outageRate
+        } else {
+            bc.Nodes[i].Online = rng.Float64() < recoverRate
+        }
+    }
+}
+
+// runSlots 依次运行 [from, to] 内的时隙，排定出块人在线则出块，否则记录错过的时隙
+func (bc *Blockchain) runSlots(s *Scheduler, from, to int64) {
+    for slot := from; slot <= to; slot++ {
+        bc.simulateOutages()
+        bc.simulateTransfers(transfersPerSlot)
+        bc.simulateVoteChanges(voteChangesPerSlot)
+        bc.simulateProxyChanges(proxyChangesPerSlot)
+        bc.simulateGovernance()
+        bc.simulateUnjail()
+        epoch := s.Epoch
+        s.advance(slot, bc.Blocks[len(bc.Blocks)-1])
+        if s.Epoch != epoch {
+            bc.payKickbacks(epoch)
+            fmt.Printf("Epoch %d starts at block %d with %d delegates, %ds slots\n", s.Epoch, s.EpochStart, len(s.Delegates), s.SlotInterval)
+        }
+        producer := s.producerAt(slot)
+        node := bc.findNode(producer)
+        if node == nil || !node.Online {
+            bc.MissedSlots = append(bc.MissedSlots, MissedSlot{Slot: slot, Producer: producer})
+            fmt.Printf("Slot %d missed by %s\n", slot, producer)
+            continue
+        }
+        block := bc.produceBlock(node, slot, s.Epoch, s.slotTime(slot), bc.missedSince(bc.Blocks[len(bc.Blocks)-1].Slot))
+        bc.observeBlock(block)
+        if bc.BFT {
+            bc.confirmBlock(s.activeDelegates())
+        }
+        bc.updateLastIrreversible(s.activeDelegates())
+        if err := bc.persist(); err != nil {
+            log.Printf("failed to persist block %d: %v", block.Index, err)
+        }
+        bc.simulateDoubleSign(node, block)
+    }
+}
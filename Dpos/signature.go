@@ -0,0 +1,70 @@
+package main
+
+import (
+    "crypto/ed25519"
+    "crypto/sha256"
+    "encoding/hex"
+    "fmt"
+)
+
+// keyFromSeed 由种子确定性地生成节点密钥对，地址为公钥的十六进制编码
+func keyFromSeed(seed string) (string, ed25519.PrivateKey) {
+    hashed := sha256.Sum256([]byte(seed))
+    privateKey := ed25519.NewKeyFromSeed(hashed[:])
+    return hex.EncodeToString(privateKey.Public().(ed25519.PublicKey)), privateKey
+}
+
+// publicKeyFromAddress 从地址还原公钥
+func publicKeyFromAddress(address string) (ed25519.PublicKey, error) {
+    key, err := hex.DecodeString(address)
+    if err != nil {
+        return nil, err
+    }
+    if len(key) != ed25519.PublicKeySize {
+        return nil, fmt.Errorf("invalid address length %d", len(key))
+    }
+    return ed25519.PublicKey(key), nil
+}
+
+// signBlock 出块人对区块哈希签名
+func signBlock(block *Block, privateKey ed25519.PrivateKey) {
+    block.Signature = hex.EncodeToString(ed25519.Sign(privateKey, []byte(block.Hash)))
+}
+
+// verifyBlockSignature 用出块人地址对应的公钥验证区块签名
+func verifyBlockSignature(block Block) bool {
+    publicKey, err := publicKeyFromAddress(block.Producer)
+    if err != nil {
+        return false
+    }
+    signature, err := hex.DecodeString(block.Signature)
+    if err != nil {
+        return false
+    }
+    return ed25519.Verify(publicKey, []byte(block.Hash), signature)
+}
+
+// transactionDigest 交易签名的内容，不包含签名本身
+func transactionDigest(tx Transaction) []byte {
+    tx.Signature = ""
+    digest := sha256.Sum256(encodeTransaction(tx))
+    return digest[:]
+}
+
+// signTransaction 付款方对交易签名
+func signTransaction(tx *Transaction, privateKey ed25519.PrivateKey) {
+    tx.Signature = hex.EncodeToString(ed25519.Sign(privateKey, transactionDigest(*tx)))
+}
+
+// verifyTransactionSignature 用付款方地址对应的公钥验证交易签名
+func verifyTransactionSignature(tx Transaction) bool {
+    publicKey, err := publicKeyFromAddress(tx.From)
+    if err != nil {
+        return false
+    }
+    signature, err := hex.DecodeString(tx.Signature)
+    if err != nil {
+        return false
+    }
+    return ed25519.Verify(publicKey, transactionDigest(tx), signature)
+}
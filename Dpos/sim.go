@@ -0,0 +1,359 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "log"
+    "math"
+    "math/rand"
+    "os"
+    "sort"
+    "time"
+)
+
+// rng 模拟使用的随机数源，由模拟配置的种子重新设置，同一种子和创世时间的模拟结果可复现
+var rng = rand.New(rand.NewSource(time.Now().UnixNano()))
+
+// 投票人的行为模型
+const (
+    modelRandom    = "random"    // 随机赞成若干候选人
+    modelBandwagon = "bandwagon" // 赞成当前得票最多的若干候选人
+    modelSelf      = "self"      // 候选人先投自己，其余随机
+)
+
+// SimConfig 模拟配置
+type SimConfig struct {
+    Seed         int64   `json:"seed"`
+    GenesisTime  int64   `json:"genesisTime"`
+    Nodes        int     `json:"nodes"`
+    Candidates   int     `json:"candidates"`
+    TotalTokens  int     `json:"totalTokens"`
+    Distribution string  `json:"distribution"`
+    ParetoAlpha  float64 `json:"paretoAlpha"`
+    Delegates    int     `json:"delegates"`
+    Epochs       int     `json:"epochs"`
+    EpochBlocks  int     `json:"epochBlocks"`
+    MaxMisses    int     `json:"maxConsecutiveMisses"`
+    // SlashPercent 和 VoterSlashPercent 为重复出块时受托人和其投票人被罚没的百分比
+    SlashPercent      int                `json:"slashPercent"`
+    VoterSlashPercent int                `json:"voterSlashPercent"`
+    TopNodes          int                `json:"topNodes"`
+    VoterModels       map[string]float64 `json:"voterModels"`
+    // RewardSharing 投票人分享出块奖励的规则，Collusion 非空时加入贿选和卡特尔模型
+    RewardSharing string           `json:"rewardSharing"`
+    Collusion     *CollusionConfig `json:"collusion"`
+}
+
+func defaultSimConfig() SimConfig {
+    return SimConfig{
+        Nodes:             100,
+        Candidates:        100,
+        TotalTokens:       10000,
+        Distribution:      "uniform",
+        ParetoAlpha:       1.16,
+        Delegates:         defaultDelegateCount,
+        Epochs:            3,
+        MaxMisses:         defaultMaxConsecutiveMisses,
+        SlashPercent:      defaultSlashPercent,
+        VoterSlashPercent: defaultVoterSlashPercent,
+        TopNodes:          30,
+        VoterModels:       map[string]float64{modelRandom: 1},
+        RewardSharing:     RewardProportional,
+    }
+}
+
+// loadSimConfig 读取模拟配置，未填写的字段取默认值
+func loadSimConfig(path string) (SimConfig, error) {
+    config := defaultSimConfig()
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return config, err
+    }
+    models := config.VoterModels
+    config.VoterModels = nil
+    if err := json.Unmarshal(data, &config); err != nil {
+        return config, fmt.Errorf("parse %s: %w", path, err)
+    }
+    if config.VoterModels == nil {
+        config.VoterModels = models
+    }
+    return config, config.validate()
+}
+
+func (c SimConfig) validate() error {
+    if c.Nodes <= 0 || c.Candidates <= 0 || c.Candidates > c.Nodes {
+        return fmt.Errorf("need 1 to %d candidates among %d nodes", c.Nodes, c.Nodes)
+    }
+    if c.TotalTokens < c.Nodes {
+        return fmt.Errorf("totalTokens %d must give every node at least 1 token", c.TotalTokens)
+    }
+    if c.Distribution != "uniform" && c.Distribution != "pareto" {
+        return fmt.Errorf("unknown distribution %q", c.Distribution)
+    }
+    if c.Distribution == "pareto" && c.ParetoAlpha <= 0 {
+        return fmt.Errorf("paretoAlpha must be positive")
+    }
+    if c.Delegates <= 0 || c.Epochs <= 0 {
+        return fmt.Errorf("delegates and epochs must be positive")
+    }
+    if c.EpochBlocks < 0 {
+        return fmt.Errorf("epochBlocks must not be negative")
+    }
+    if c.MaxMisses <= 0 {
+        return fmt.Errorf("maxConsecutiveMisses must be positive")
+    }
+    if c.SlashPercent < 0 || c.SlashPercent > 100 || c.VoterSlashPercent < 0 || c.VoterSlashPercent > 100 {
+        return fmt.Errorf("slashPercent and voterSlashPercent must be 0 to 100")
+    }
+    total := 0.0
+    for model, weight := range c.VoterModels {
+        if model != modelRandom && model != modelBandwagon && model != modelSelf && model != modelSeller {
+            return fmt.Errorf("unknown voter model %q", model)
+        }
+        if weight < 0 {
+            return fmt.Errorf("voter model %s has negative weight", model)
+        }
+        total += weight
+    }
+    if total == 0 {
+        return fmt.Errorf("voterModels needs a positive weight")
+    }
+    if err := validRewardSharing(c.RewardSharing); err != nil {
+        return err
+    }
+    if c.Collusion != nil {
+        return c.Collusion.validate(c.Candidates)
+    }
+    return nil
+}
+
+// tokenAmounts 按分布生成各节点的持币量：uniform 为均匀分布，pareto 为帕累托分布，
+// 按比例缩放到 TotalTokens，每个节点至少 1 个代币
+func (c SimConfig) tokenAmounts() []int {
+    weights := make([]float64, c.Nodes)
+    sum := 0.0
+    for i := range weights {
+        if c.Distribution == "pareto" {
+            weights[i] = 1 / math.Pow(1-rng.Float64(), 1/c.ParetoAlpha)
+        } else {
+            weights[i] = rng.Float64()
+        }
+        sum += weights[i]
+    }
+    amounts := make([]int, c.Nodes)
+    for i, weight := range weights {
+        amounts[i] = 1 + int(weight/sum*float64(c.TotalTokens-c.Nodes))
+    }
+    return amounts
+}
+
+// assignModels 按权重为每个节点随机分配投票模型，配置了卡特尔时再从候选人中随机选出卡特尔成员
+func (c SimConfig) assignModels() []string {
+    models := make([]string, 0, len(c.VoterModels))
+    total := 0.0
+    for model, weight := range c.VoterModels {
+        models = append(models, model)
+        total += weight
+    }
+    sort.Strings(models)
+    assigned := make([]string, c.Nodes)
+    for i := range assigned {
+        pick := rng.Float64() * total
+        for _, model := range models {
+            assigned[i] = model
+            if pick -= c.VoterModels[model]; pick < 0 {
+                break
+            }
+        }
+    }
+    if c.Collusion != nil {
+        for _, i := range rng.Perm(c.Candidates)[:c.Collusion.CartelSize] {
+            assigned[i] = modelCartel
+        }
+    }
+    return assigned
+}
+
+// approvalsFor 按节点的投票模型选择赞成的候选人
+func (bc *Blockchain) approvalsFor(node *Node) []string {
+    switch node.model {
+    case modelBandwagon:
+        ranked := bc.rankCandidates()
+        return ranked[:min(1+rng.Intn(maxVotesPerVoter), len(ranked))]
+    case modelSeller:
+        return bc.sellerApprovals(node)
+    case modelCartel:
+        return bc.cartel.Members
+    case modelSelf:
+        approvals := randomApprovals(bc.Candidates)
+        for i, candidate := range approvals {
+            if candidate == node.Address {
+                approvals[0], approvals[i] = approvals[i], approvals[0]
+                return approvals
+            }
+        }
+        for _, candidate := range bc.Candidates {
+            if candidate == node.Address {
+                approvals[len(approvals)-1] = node.Address
+                break
+            }
+        }
+        return approvals
+    default:
+        return randomApprovals(bc.Candidates)
+    }
+}
+
+// rankCandidates 候选人按当前得票排序，创世前按候选人自己的持币量排序
+func (bc *Blockchain) rankCandidates() []string {
+    if bc.State != nil {
+        tallies := bc.State.tally(bc.State.candidates())
+        ranked := make([]string, len(tallies))
+        for i, tally := range tallies {
+            ranked[i] = tally.Candidate
+        }
+        return ranked
+    }
+    ranked := append([]string(nil), bc.Candidates...)
+    tokens := make(map[string]int, len(bc.Nodes))
+    for _, node := range bc.Nodes {
+        tokens[node.Address] = node.TokenAmount
+    }
+    sort.SliceStable(ranked, func(i, j int) bool {
+        return tokens[ranked[i]] > tokens[ranked[j]]
+    })
+    return ranked
+}
+
+// nakamotoCoefficient 按权重从高到低，合计超过总权重三分之一所需的最少受托人数；
+// 超过三分之一的受托人合谋即可阻止区块达到不可逆
+func nakamotoCoefficient(weights []int) int {
+    sorted := append([]int(nil), weights...)
+    sort.Sort(sort.Reverse(sort.IntSlice(sorted)))
+    total := 0
+    for _, w := range sorted {
+        total += w
+    }
+    sum := 0
+    for i, w := range sorted {
+        sum += w
+        if 3*sum > total {
+            return i + 1
+        }
+    }
+    return len(sorted)
+}
+
+// gini 权重分布的基尼系数，0 表示完全平均
+func gini(weights []int) float64 {
+    n := len(weights)
+    if n == 0 {
+        return 0
+    }
+    sorted := append([]int(nil), weights...)
+    sort.Ints(sorted)
+    total, weighted := 0.0, 0.0
+    for i, w := range sorted {
+        total += float64(w)
+        weighted += float64(i+1) * float64(w)
+    }
+    if total == 0 {
+        return 0
+    }
+    return 2*weighted/(float64(n)*total) - float64(n+1)/float64(n)
+}
+
+// printDecentralization 打印每届受托人得票的 Nakamoto 系数、基尼系数和最大受托人的得票占比
+func printDecentralization(elections []ElectionResult) {
+    fmt.Println("Decentralization by epoch (delegate vote weight):")
+    fmt.Println("epoch  delegates  nakamoto  gini   top share")
+    for _, result := range elections {
+        weights := make([]int, len(result.Delegates))
+        total := 0
+        for i := range result.Delegates {
+            weights[i] = result.Tallies[i].Votes
+            total += weights[i]
+        }
+        topShare := 0.0
+        if total > 0 {
+            topShare = float64(weights[0]) / float64(total)
+        }
+        fmt.Printf("%5d  %9d  %8d  %.3f  %8.1f%%\n", result.Epoch, len(result.Delegates),
+            nakamotoCoefficient(weights), gini(weights), 100*topShare)
+    }
+}
+
+// runSimulation 按配置生成节点和创世区块，出满 Epochs 届的区块数后打印统计并返回模拟的链；
+// dataDir 非空时把区块和快照写入其中，结束时从中重新恢复并核对链上状态
+func runSimulation(config SimConfig, bft bool, dataDir string) *Blockchain {
+    if config.Seed == 0 {
+        config.Seed = time.Now().UnixNano()
+    }
+    if config.GenesisTime == 0 {
+        config.GenesisTime = time.Now().Unix()
+    }
+    rng.Seed(config.Seed)
+    fmt.Printf("Simulation seed %d, genesis time %d\n", config.Seed, config.GenesisTime)
+
+    blockchain := Blockchain{BFT: bft, Params: defaultParams(config.Delegates)}
+    blockchain.Params.RewardSharing = config.RewardSharing
+    blockchain.Params.EpochBlocks = config.EpochBlocks
+    blockchain.Params.MaxConsecutiveMisses = config.MaxMisses
+    blockchain.Params.SlashPercent = config.SlashPercent
+    blockchain.Params.VoterSlashPercent = config.VoterSlashPercent
+    initializeNodes(&blockchain, config)
+    if config.Collusion != nil {
+        blockchain.cartel = newCartel(blockchain.Nodes, config.Collusion.KickbackPercent)
+    }
+    simulateVoting(&blockchain)
+    simulateCommissions(&blockchain)
+    blockchain.createGenesisBlock(config.GenesisTime)
+    if dataDir != "" {
+        store, err := openStore(dataDir)
+        if err != nil {
+            log.Fatal(err)
+        }
+        store.loadBlocks()
+        blockchain.store = store
+        if err := blockchain.persist(); err != nil {
+            log.Fatal(err)
+        }
+    }
+    scheduler, err := newScheduler(blockchain.Blocks[0], func(epoch int) []string {
+        return blockchain.runElection(epoch).Delegates
+    }, func(address string) bool {
+        return blockchain.State.isExcluded(address)
+    }, func(epoch int) Params {
+        return blockchain.State.paramsAt(epoch)
+    })
+    if err != nil {
+        log.Fatal(err)
+    }
+    sortedNodes := sortNodesByVoteCount(blockchain.Nodes)
+    printTopNodes(sortedNodes, config.TopNodes)
+    printElectionResult(blockchain.Elections[0])
+
+    // 届的切换只发生在轮次边界，运行到第 Epochs 届结束、下一个时隙将开始新一届为止
+    for slot := int64(1); scheduler.Epoch < config.Epochs || !scheduler.startsEpoch(slot, blockchain.Blocks[len(blockchain.Blocks)-1]); slot++ {
+        blockchain.runSlots(scheduler, slot, slot)
+    }
+    fmt.Printf("Produced %d blocks in %d rounds and %d epochs, missed %d slots\n",
+        len(blockchain.Blocks)-1, scheduler.Round, scheduler.Epoch, len(blockchain.MissedSlots))
+
+    printEarnings(blockchain.State, 10)
+    printReliability(blockchain.State)
+    printProposals(blockchain.State)
+    printDecentralization(blockchain.Elections)
+    if blockchain.cartel != nil {
+        printCapture(blockchain.cartel, blockchain.Elections)
+    }
+    fmt.Printf("Last irreversible block: %d\n", blockchain.LastIrreversible)
+    blockchain.simulateFinalFork()
+
+    isValid := blockchain.validate()
+    fmt.Printf("Blockchain valid: %t\n", isValid)
+    if dataDir != "" {
+        blockchain.verifyRestore(dataDir)
+    }
+    return &blockchain
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "sort"
+)
+
+const (
+    defaultSlashPercent      = 50
+    defaultVoterSlashPercent = 10
+    doubleSignRate           = 0.01 // 模拟受托人在同一高度签出两个区块的概率
+)
+
+var errBadEvidence = errors.New("invalid double-production evidence")
+
+// header 去掉交易后的区块头，哈希和签名仍可独立校验
+func header(block Block) Block {
+    block.Transactions = nil
+    return block
+}
+
+// verifyEvidence 校验证据为同一出块人在同一时隙签名的两个不同区块头
+func verifyEvidence(evidence []Block) error {
+    if len(evidence) != 2 {
+        return fmt.Errorf("%w: need 2 headers, got %d", errBadEvidence, len(evidence))
+    }
+    a, b := evidence[0], evidence[1]
+    if a.Producer != b.Producer || a.Slot != b.Slot {
+        return fmt.Errorf("%w: headers are not from the same producer in the same slot", errBadEvidence)
+    }
+    if a.Hash == b.Hash {
+        return fmt.Errorf("%w: headers are identical", errBadEvidence)
+    }
+    for _, h := range evidence {
+        if headerHash(h) != h.Hash || !verifyBlockSignature(h) {
+            return fmt.Errorf("%w: header %s is not signed by %s", errBadEvidence, h.Hash, h.Producer)
+        }
+    }
+    return nil
+}
+
+// applyEvidence 罚没重复出块的受托人及其投票人的部分代币，并将其永久移出候选人
+func (s *State) applyEvidence(tx Transaction) error {
+    if err := verifyEvidence(tx.Evidence); err != nil {
+        return err
+    }
+    offender := tx.Evidence[0].Producer
+    if !s.Candidates[offender] {
+        return fmt.Errorf("%w: %s is not a candidate", errBadEvidence, offender)
+    }
+    if s.Slashed[offender] {
+        return fmt.Errorf("%w: %s is already slashed", errBadEvidence, offender)
+    }
+    if s.Params.VoterSlashPercent > 0 {
+        for _, backer := range s.backers(offender, s.Proxies) {
+            if backer != offender {
+                s.slashStake(backer, s.Params.VoterSlashPercent)
+            }
+        }
+    }
+    s.slashStake(offender, s.Params.SlashPercent)
+    for i := range s.Unbonding {
+        if s.Unbonding[i].Address == offender {
+            s.Unbonding[i].Amount -= s.Unbonding[i].Amount * s.Params.SlashPercent / 100
+        }
+    }
+    s.Slashed[offender] = true
+    delete(s.Jailed, offender)
+    return nil
+}
+
+// slashStake 销毁账户质押量的 percent 个百分点
+func (s *State) slashStake(address string, percent int) {
+    s.Staked[address] -= s.Staked[address] * percent / 100
+    if s.Staked[address] == 0 {
+        delete(s.Staked, address)
+        delete(s.Approvals, address)
+    }
+}
+
+// slotKey 出块人和时隙，每个出块人在每个时隙只能签出一个区块
+type slotKey struct {
+    producer string
+    slot     int64
+}
+
+// observeBlock 记录每个出块人在每个时隙第一次见到的区块头，同一出块人在同一时隙签出不同区块时返回证据
+func (bc *Blockchain) observeBlock(block Block) []Block {
+    if bc.seen == nil {
+        bc.seen = make(map[slotKey]Block)
+    }
+    key := slotKey{block.Producer, block.Slot}
+    first, ok := bc.seen[key]
+    if !ok {
+        bc.seen[key] = header(block)
+        return nil
+    }
+    if first.Hash == block.Hash {
+        return nil
+    }
+    return []Block{first, header(block)}
+}
+
+// blockAtSlot 链上位于 slot 的区块，链上区块的时隙严格递增
+func (bc *Blockchain) blockAtSlot(slot int64) (Block, bool) {
+    i := sort.Search(len(bc.Blocks), func(i int) bool { return bc.Blocks[i].Slot >= slot })
+    if i == len(bc.Blocks) || bc.Blocks[i].Slot != slot {
+        return Block{}, false
+    }
+    return bc.Blocks[i], true
+}
+
+// simulateDoubleSign 出块人以一定概率对同一高度再签一个不同的区块并广播，
+// 收到它的在线节点发现冲突后提交证据
+func (bc *Blockchain) simulateDoubleSign(producer *Node, block Block) {
+    if rng.Float64() >= doubleSignRate {
+        return
+    }
+    conflict := block
+    conflict.Nonce++
+    conflict.Hash = calculateHash(conflict)
+    signBlock(&conflict, producer.privateKey)
+    evidence := bc.observeBlock(conflict)
+    if evidence == nil {
+        return
+    }
+    fmt.Printf("Delegate %s signed two blocks in slot %d\n", producer.Address, block.Slot)
+    reporter := &bc.Nodes[rng.Intn(len(bc.Nodes))]
+    if !reporter.Online || reporter.Address == producer.Address {
+        return
+    }
+    bc.signAndSubmit(reporter, Transaction{Type: TxEvidence, Evidence: evidence})
+}
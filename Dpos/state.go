@@ -0,0 +1,261 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "sort"
+)
+
+var (
+    errBadSignature = errors.New("invalid transaction signature")
+    errBadNonce     = errors.New("unexpected transaction nonce")
+    errOverdraft    = errors.New("insufficient balance")
+    errBadAmount    = errors.New("amount must be positive")
+    errBadType      = errors.New("unknown transaction type")
+)
+
+// State 由创世区块和后续区块中的交易依次推导出的链上状态
+type State struct {
+    Params      Params
+    Height      int
+    Epoch       int
+    Candidates  map[string]bool
+    Balances    map[string]int
+    Nonces      map[string]int64
+    Staked      map[string]int
+    Approvals   map[string][]string
+    Unbonding   []Unbonding
+    Commission  map[string]int
+    Earnings    map[int]map[string]Earnings
+    Performance map[string]DelegateStats
+    Jailed      map[string]bool
+    Slashed     map[string]bool
+    // Proxies 本届生效的代理关系，PendingProxies 为下一届生效的变更，空字符串表示取消代理
+    Proxies        map[string]string
+    PendingProxies map[string]string
+    Proposals      []Proposal
+}
+
+// Params 共识参数，创世时确定，之后可经链上治理在届的边界修改
+type Params struct {
+    DelegateCount int
+    SlotInterval  int64  // 每个出块时隙的秒数
+    BlockReward   int    // 每个区块发放给出块受托人及其投票人的奖励
+    RewardSharing string // 投票人分享出块奖励的规则
+    EpochBlocks   int    // 每届的区块数，为0时取受托人数的 defaultEpochRounds 倍
+    // MaxConsecutiveMisses 受托人连续错过这么多个时隙后被监禁，退出出块顺序
+    MaxConsecutiveMisses int
+    SlashPercent         int // 重复出块的受托人被罚没的质押及解绑中代币的百分比
+    VoterSlashPercent    int // 赞成该受托人的投票人被罚没的质押百分比，为0则不处罚投票人
+}
+
+// epochLength 每届的区块数
+func (p Params) epochLength() int {
+    if p.EpochBlocks > 0 {
+        return p.EpochBlocks
+    }
+    return defaultEpochRounds * p.DelegateCount
+}
+
+func newState(candidates []string, params Params) *State {
+    s := &State{
+        Params:         params,
+        Candidates:     make(map[string]bool, len(candidates)),
+        Balances:       make(map[string]int),
+        Nonces:         make(map[string]int64),
+        Staked:         make(map[string]int),
+        Approvals:      make(map[string][]string),
+        Commission:     make(map[string]int),
+        Earnings:       make(map[int]map[string]Earnings),
+        Performance:    make(map[string]DelegateStats),
+        Jailed:         make(map[string]bool),
+        Slashed:        make(map[string]bool),
+        Proxies:        make(map[string]string),
+        PendingProxies: make(map[string]string),
+    }
+    for _, candidate := range candidates {
+        s.Candidates[candidate] = true
+    }
+    return s
+}
+
+func (s *State) clone() *State {
+    c := newState(nil, s.Params)
+    c.Height = s.Height
+    c.Epoch = s.Epoch
+    for candidate := range s.Candidates {
+        c.Candidates[candidate] = true
+    }
+    for address, balance := range s.Balances {
+        c.Balances[address] = balance
+    }
+    for address, nonce := range s.Nonces {
+        c.Nonces[address] = nonce
+    }
+    for address, staked := range s.Staked {
+        c.Staked[address] = staked
+    }
+    for address, approvals := range s.Approvals {
+        c.Approvals[address] = append([]string(nil), approvals...)
+    }
+    c.Unbonding = append([]Unbonding(nil), s.Unbonding...)
+    for address, commission := range s.Commission {
+        c.Commission[address] = commission
+    }
+    for epoch, accounts := range s.Earnings {
+        c.Earnings[epoch] = make(map[string]Earnings, len(accounts))
+        for address, earned := range accounts {
+            c.Earnings[epoch][address] = earned
+        }
+    }
+    for address, stats := range s.Performance {
+        c.Performance[address] = stats
+    }
+    for address := range s.Jailed {
+        c.Jailed[address] = true
+    }
+    for address := range s.Slashed {
+        c.Slashed[address] = true
+    }
+    for account, proxy := range s.Proxies {
+        c.Proxies[account] = proxy
+    }
+    for account, proxy := range s.PendingProxies {
+        c.PendingProxies[account] = proxy
+    }
+    for _, p := range s.Proposals {
+        approvals := make(map[string]int, len(p.Approvals))
+        for account, weight := range p.Approvals {
+            approvals[account] = weight
+        }
+        p.Approvals = approvals
+        c.Proposals = append(c.Proposals, p)
+    }
+    return c
+}
+
+// applyGenesis 先记入没有付款方的初始分配，再执行创世区块中的其余交易
+func (s *State) applyGenesis(genesis Block) error {
+    for _, tx := range genesis.Transactions {
+        if tx.From != "" {
+            continue
+        }
+        if tx.Type != TxTransfer || tx.Amount <= 0 {
+            return fmt.Errorf("invalid genesis allocation to %s", tx.To)
+        }
+        s.Balances[tx.To] += tx.Amount
+    }
+    for i, tx := range genesis.Transactions {
+        if tx.From == "" {
+            continue
+        }
+        if err := s.applyTransaction(tx); err != nil {
+            return fmt.Errorf("transaction %d: %w", i, err)
+        }
+    }
+    return nil
+}
+
+// beginBlock 进入新区块：新一届的第一个区块先结算到期的参数提案、让代理变更生效，
+// 再记录此前错过的时隙和本区块的出块，并释放到期的解绑代币，返回新被监禁的受托人
+func (s *State) beginBlock(index, epoch int, producer string, missed []string) []string {
+    s.Height = index
+    if epoch != s.Epoch {
+        s.enactProposals(epoch)
+        s.promoteProxies()
+        s.Epoch = epoch
+    }
+    jailed := s.recordSlots(missed, producer)
+    s.releaseUnbonding()
+    return jailed
+}
+
+// applyBlock 在 beginBlock 之后依次执行区块中的交易，最后发放出块奖励；任何一笔交易无效则整个区块无效。
+// missed 为该区块与上一区块之间错过的时隙的排定出块人
+func (s *State) applyBlock(block Block, missed []string) error {
+    s.beginBlock(block.Index, block.Epoch, block.Producer, missed)
+    for i, tx := range block.Transactions {
+        if err := s.applyTransaction(tx); err != nil {
+            return fmt.Errorf("transaction %d: %w", i, err)
+        }
+    }
+    s.distributeReward(block.Producer, block.Epoch)
+    return nil
+}
+
+// applyTransaction 校验签名和序号后按交易类型执行，序号必须比该账户上一笔交易大1
+func (s *State) applyTransaction(tx Transaction) error {
+    if !verifyTransactionSignature(tx) {
+        return errBadSignature
+    }
+    if tx.Nonce != s.Nonces[tx.From]+1 {
+        return fmt.Errorf("%w: got %d, want %d", errBadNonce, tx.Nonce, s.Nonces[tx.From]+1)
+    }
+    var err error
+    switch tx.Type {
+    case TxTransfer:
+        err = s.applyTransfer(tx)
+    case TxVote:
+        err = s.applyVote(tx)
+    case TxUnvote:
+        err = s.applyUnvote(tx)
+    case TxCommission:
+        err = s.applyCommission(tx)
+    case TxUnjail:
+        err = s.applyUnjail(tx)
+    case TxEvidence:
+        err = s.applyEvidence(tx)
+    case TxProxy:
+        err = s.applyProxy(tx)
+    case TxPropose:
+        err = s.applyProposal(tx)
+    case TxApprove:
+        err = s.applyApproval(tx)
+    default:
+        err = fmt.Errorf("%w: %q", errBadType, tx.Type)
+    }
+    if err != nil {
+        return err
+    }
+    s.Nonces[tx.From] = tx.Nonce
+    return nil
+}
+
+// votes 按投票人地址排序展开当前的全部赞成票
+func (s *State) votes() []Vote {
+    voters := make([]string, 0, len(s.Approvals))
+    for voter := range s.Approvals {
+        voters = append(voters, voter)
+    }
+    sort.Strings(voters)
+    var votes []Vote
+    for _, voter := range voters {
+        for _, candidate := range s.Approvals[voter] {
+            votes = append(votes, Vote{Voter: voter, Candidate: candidate})
+        }
+    }
+    return votes
+}
+
+// candidates 按地址排序的候选人列表，被监禁或罚没的候选人不参加选举
+func (s *State) candidates() []string {
+    candidates := make([]string, 0, len(s.Candidates))
+    for candidate := range s.Candidates {
+        if s.isExcluded(candidate) {
+            continue
+        }
+        candidates = append(candidates, candidate)
+    }
+    sort.Strings(candidates)
+    return candidates
+}
+
+// isExcluded 受托人被监禁或因重复出块被罚没，不再排入出块顺序
+func (s *State) isExcluded(address string) bool {
+    return s.Jailed[address] || s.Slashed[address]
+}
+
+// elect 按当前质押和投票选出第 epoch 届的受托人，人数为该届生效的参数
+func (s *State) elect(epoch int) []string {
+    return electDelegates(s.tally(s.candidates()), s.paramsAt(epoch).DelegateCount)
+}
@@ -0,0 +1,389 @@
+package main
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+const keepSnapshots = 3 // 数据目录中保留的最新快照个数
+
+var (
+    errStoreMismatch = errors.New("data directory belongs to a different chain")
+    errBadSnapshot   = errors.New("invalid snapshot")
+)
+
+// Store 数据目录：blocks 下每个区块一个文件，snapshots 下是若干不可逆高度上的链上状态和调度器快照，
+// irreversible.json 为最近写入的不可逆区块
+type Store struct {
+    dir       string
+    hashes    []string // 磁盘上各高度区块的哈希
+    confirmed []bool   // 磁盘上各高度区块是否带有 BFT 确认
+    lib       int      // 磁盘上记录的不可逆高度
+}
+
+// Finality 不可逆区块的高度和哈希
+type Finality struct {
+    Height int
+    Hash   string
+}
+
+// Snapshot 第 Height 个区块执行完之后的链上状态和调度器，Checksum 为其余字段编码后的哈希
+type Snapshot struct {
+    Height    int
+    Hash      string
+    State     *State
+    Scheduler *Scheduler
+    Checksum  string
+}
+
+// openStore 打开数据目录，不存在时创建
+func openStore(dir string) (*Store, error) {
+    for _, sub := range []string{"blocks", "snapshots"} {
+        if err := os.MkdirAll(filepath.Join(dir, sub), 0o755); err != nil {
+            return nil, err
+        }
+    }
+    return &Store{dir: dir}, nil
+}
+
+func (st *Store) blockPath(index int) string {
+    return filepath.Join(st.dir, "blocks", fmt.Sprintf("%08d.json", index))
+}
+
+func (st *Store) snapshotPath(height int) string {
+    return filepath.Join(st.dir, "snapshots", fmt.Sprintf("%08d.json", height))
+}
+
+func (st *Store) finalityPath() string {
+    return filepath.Join(st.dir, "irreversible.json")
+}
+
+// writeFile 先写临时文件再原子替换
+func writeFile(path string, v interface{}) error {
+    data, err := json.Marshal(v)
+    if err != nil {
+        return err
+    }
+    tmp := path + ".tmp"
+    if err := os.WriteFile(tmp, data, 0o644); err != nil {
+        os.Remove(tmp)
+        return err
+    }
+    return os.Rename(tmp, path)
+}
+
+// loadBlocks 从高度0开始依次读取区块，遇到缺失、无法解码或高度不符的文件即停止
+func (st *Store) loadBlocks() []Block {
+    var blocks []Block
+    for {
+        data, err := os.ReadFile(st.blockPath(len(blocks)))
+        if err != nil {
+            break
+        }
+        var block Block
+        if err := json.Unmarshal(data, &block); err != nil || block.Index != len(blocks) {
+            fmt.Printf("Stored block %d is corrupt\n", len(blocks))
+            break
+        }
+        blocks = append(blocks, block)
+    }
+    st.hashes = st.hashes[:0]
+    st.confirmed = st.confirmed[:0]
+    for _, block := range blocks {
+        st.hashes = append(st.hashes, block.Hash)
+        st.confirmed = append(st.confirmed, block.Confirmation != nil)
+    }
+    return blocks
+}
+
+// loadFinality 读取磁盘上记录的不可逆区块，记录不存在时为创世区块
+func (st *Store) loadFinality() (Finality, error) {
+    var f Finality
+    data, err := os.ReadFile(st.finalityPath())
+    if os.IsNotExist(err) {
+        return f, nil
+    }
+    if err != nil {
+        return f, err
+    }
+    return f, json.Unmarshal(data, &f)
+}
+
+// truncate 删除高度不低于 from 的区块及其之后的快照
+func (st *Store) truncate(from int) error {
+    for index := from; ; index++ {
+        err := os.Remove(st.blockPath(index))
+        if os.IsNotExist(err) {
+            break
+        }
+        if err != nil {
+            return err
+        }
+    }
+    if from < len(st.hashes) {
+        st.hashes = st.hashes[:from]
+        st.confirmed = st.confirmed[:from]
+    }
+    for _, height := range st.snapshotHeights() {
+        if height >= from {
+            if err := os.Remove(st.snapshotPath(height)); err != nil {
+                return err
+            }
+        }
+    }
+    return nil
+}
+
+// snapshotHeights 数据目录中快照的高度，从高到低排列
+func (st *Store) snapshotHeights() []int {
+    entries, _ := os.ReadDir(filepath.Join(st.dir, "snapshots"))
+    var heights []int
+    for _, entry := range entries {
+        height, err := strconv.Atoi(strings.TrimSuffix(entry.Name(), ".json"))
+        if err == nil {
+            heights = append(heights, height)
+        }
+    }
+    sort.Sort(sort.Reverse(sort.IntSlice(heights)))
+    return heights
+}
+
+func (snap *Snapshot) checksum() string {
+    c := *snap
+    c.Checksum = ""
+    data, _ := json.Marshal(c)
+    hashed := sha256.Sum256(data)
+    return hex.EncodeToString(hashed[:])
+}
+
+// saveSnapshot 写入快照并删除较旧的快照，只保留最新的 keepSnapshots 个
+func (st *Store) saveSnapshot(snap *Snapshot) error {
+    snap.Checksum = snap.checksum()
+    if err := writeFile(st.snapshotPath(snap.Height), snap); err != nil {
+        return err
+    }
+    for i, height := range st.snapshotHeights() {
+        if i >= keepSnapshots {
+            os.Remove(st.snapshotPath(height))
+        }
+    }
+    return nil
+}
+
+// loadSnapshot 读取快照并校验其哈希，且快照所在高度的区块必须与 blocks 中的一致
+func (st *Store) loadSnapshot(height int, blocks []Block) (*Snapshot, error) {
+    data, err := os.ReadFile(st.snapshotPath(height))
+    if err != nil {
+        return nil, err
+    }
+    var snap Snapshot
+    if err := json.Unmarshal(data, &snap); err != nil {
+        return nil, fmt.Errorf("%w: %v", errBadSnapshot, err)
+    }
+    if snap.State == nil || snap.Scheduler == nil || snap.checksum() != snap.Checksum {
+        return nil, fmt.Errorf("%w: checksum mismatch at height %d", errBadSnapshot, height)
+    }
+    if snap.Height != height || height >= len(blocks) || blocks[height].Hash != snap.Hash {
+        return nil, fmt.Errorf("%w: block %d does not match the chain", errBadSnapshot, height)
+    }
+    return &snap, nil
+}
+
+// bind 让从快照恢复的调度器读取 state
+func (s *Scheduler) bind(state *State) {
+    s.elect = state.elect
+    s.excluded = state.isExcluded
+    s.params = state.paramsAt
+}
+
+// resumePoint 选出与 blocks 相符的最新有效快照，返回其状态、调度器和下一个待回放的高度，
+// 比它新的损坏或不相符的快照被删除；没有可用的快照时从创世区块开始
+func (st *Store) resumePoint(blocks []Block, candidates []string, params Params) (*State, *Scheduler, int, error) {
+    for _, height := range st.snapshotHeights() {
+        snap, err := st.loadSnapshot(height, blocks)
+        if err != nil {
+            fmt.Printf("Removing snapshot %d: %v\n", height, err)
+            os.Remove(st.snapshotPath(height))
+            continue
+        }
+        snap.Scheduler.bind(snap.State)
+        return snap.State, snap.Scheduler, height + 1, nil
+    }
+    state, scheduler, err := replay(blocks[:1], candidates, params)
+    return state, scheduler, 1, err
+}
+
+// verifyLinks 校验区块的高度、哈希、前一区块哈希和出块签名，返回从创世区块起连续有效的区块数
+func verifyLinks(blocks []Block) int {
+    for i := 1; i < len(blocks); i++ {
+        block := blocks[i]
+        if block.Index != i || block.PreviousHash != blocks[i-1].Hash || calculateHash(block) != block.Hash || !verifyBlockSignature(block) {
+            return i
+        }
+    }
+    return len(blocks)
+}
+
+// persist 把与磁盘上不同的区块写入数据目录并删除被回滚的区块，已写入后才得到 BFT 确认的区块重新写入，
+// 并记录不可逆区块；不可逆高度比最新快照高出一届的区块数时，在不可逆区块上写入新快照
+func (bc *Blockchain) persist() error {
+    st := bc.store
+    if st == nil {
+        return nil
+    }
+    same := 0
+    for same < len(st.hashes) && same < len(bc.Blocks) && st.hashes[same] == bc.Blocks[same].Hash {
+        same++
+    }
+    if same < len(st.hashes) {
+        if err := st.truncate(same); err != nil {
+            return err
+        }
+    }
+    for i, confirmed := range st.confirmed {
+        if block := bc.Blocks[i]; (block.Confirmation != nil) != confirmed {
+            if err := writeFile(st.blockPath(block.Index), block); err != nil {
+                return err
+            }
+            st.confirmed[i] = block.Confirmation != nil
+        }
+    }
+    for _, block := range bc.Blocks[same:] {
+        if err := writeFile(st.blockPath(block.Index), block); err != nil {
+            return err
+        }
+        st.hashes = append(st.hashes, block.Hash)
+        st.confirmed = append(st.confirmed, block.Confirmation != nil)
+    }
+    if bc.LastIrreversible != st.lib {
+        f := Finality{Height: bc.LastIrreversible, Hash: bc.Blocks[bc.LastIrreversible].Hash}
+        if err := writeFile(st.finalityPath(), f); err != nil {
+            return err
+        }
+        st.lib = bc.LastIrreversible
+    }
+    latest := 0
+    if heights := st.snapshotHeights(); len(heights) > 0 {
+        latest = heights[0]
+    }
+    if bc.LastIrreversible-latest < bc.Params.epochLength() {
+        return nil
+    }
+    blocks := bc.Blocks[:bc.LastIrreversible+1]
+    state, scheduler, from, err := st.resumePoint(blocks, bc.Candidates, bc.Params)
+    if err != nil {
+        return err
+    }
+    if _, err := replayBlocks(state, scheduler, blocks, from); err != nil {
+        return err
+    }
+    return st.saveSnapshot(&Snapshot{
+        Height:    bc.LastIrreversible,
+        Hash:      blocks[bc.LastIrreversible].Hash,
+        State:     state,
+        Scheduler: scheduler,
+    })
+}
+
+// restore 从数据目录恢复链：校验全部区块的哈希链接和签名，再从最新的有效快照回放之后的区块，
+// 重建余额、投票和受托人，返回推进到链尾的调度器；链接或回放失败的区块及之后的区块从磁盘删除。数据目录为空时写入创世区块
+func (bc *Blockchain) restore(st *Store) (*Scheduler, error) {
+    bc.store = st
+    blocks := st.loadBlocks()
+    if len(blocks) == 0 {
+        state, scheduler, err := replay(bc.Blocks, bc.Candidates, bc.Params)
+        if err != nil {
+            return nil, err
+        }
+        bc.State = state
+        return scheduler, bc.persist()
+    }
+    if blocks[0].Hash != bc.Blocks[0].Hash {
+        return nil, fmt.Errorf("%w: genesis %s, expected %s", errStoreMismatch, blocks[0].Hash, bc.Blocks[0].Hash)
+    }
+    if valid := verifyLinks(blocks); valid < len(blocks) {
+        fmt.Printf("Stored block %d is not linked to the chain, dropping %d blocks\n", valid, len(blocks)-valid)
+        blocks = blocks[:valid]
+    }
+    state, scheduler, from, err := st.resumePoint(blocks, bc.Candidates, bc.Params)
+    if err != nil {
+        return nil, err
+    }
+    if bad, err := replayBlocks(state, scheduler, blocks, from); err != nil {
+        fmt.Printf("Dropping stored blocks from %d: %v\n", bad, err)
+        // 无效区块可能已部分修改状态，截断后重新回放
+        blocks = blocks[:bad]
+        if state, scheduler, from, err = st.resumePoint(blocks, bc.Candidates, bc.Params); err != nil {
+            return nil, err
+        }
+        if _, err := replayBlocks(state, scheduler, blocks, from); err != nil {
+            return nil, err
+        }
+    }
+    if err := st.truncate(len(blocks)); err != nil {
+        return nil, err
+    }
+    bc.Blocks = blocks
+    bc.State = state
+    bc.syncTokenAmounts()
+    received := make(map[string]int)
+    for _, tally := range state.tally(state.candidates()) {
+        received[tally.Candidate] = tally.Votes
+    }
+    for i := range bc.Nodes {
+        bc.Nodes[i].VoteCount = received[bc.Nodes[i].Address]
+    }
+    bc.updateLastIrreversible(scheduler.activeDelegates())
+    // 按深度重新计算的不可逆高度可能低于之前记录的，例如 BFT 确认过的区块之后还没有足够多的出块人
+    if f, err := st.loadFinality(); err != nil {
+        fmt.Printf("Stored irreversible block is unreadable: %v\n", err)
+    } else if f.Height >= len(blocks) || blocks[f.Height].Hash != f.Hash {
+        fmt.Printf("Stored irreversible block %d is missing from the restored chain\n", f.Height)
+    } else if f.Height > bc.LastIrreversible {
+        bc.LastIrreversible = f.Height
+    }
+    st.lib = bc.LastIrreversible
+    fmt.Printf("Restored %d blocks from %s, replayed %d after height %d; epoch %d with %d delegates\n",
+        len(blocks), st.dir, len(blocks)-from, from-1, scheduler.Epoch, len(scheduler.Delegates))
+    return scheduler, nil
+}
+
+// stateChecksum 链上状态编码后的哈希，用于比较两份状态是否一致
+func stateChecksum(state *State) string {
+    data, _ := json.Marshal(state)
+    hashed := sha256.Sum256(data)
+    return hex.EncodeToString(hashed[:])
+}
+
+// verifyRestore 用同一创世区块从数据目录恢复一条新链，核对区块数、BFT 确认、不可逆高度和链上状态与内存中的一致
+func (bc *Blockchain) verifyRestore(dir string) {
+    store, err := openStore(dir)
+    if err != nil {
+        fmt.Printf("Failed to reopen %s: %v\n", dir, err)
+        return
+    }
+    restored := &Blockchain{
+        Nodes:      append([]Node(nil), bc.Nodes...),
+        Candidates: bc.Candidates,
+        Params:     bc.Params,
+        Blocks:     bc.Blocks[:1],
+    }
+    if _, err := restored.restore(store); err != nil {
+        fmt.Printf("Failed to restore from %s: %v\n", dir, err)
+        return
+    }
+    matches := len(restored.Blocks) == len(bc.Blocks) && stateChecksum(restored.State) == stateChecksum(bc.State) &&
+        restored.LastIrreversible == bc.LastIrreversible
+    for i := 0; matches && i < len(bc.Blocks); i++ {
+        matches = (restored.Blocks[i].Confirmation != nil) == (bc.Blocks[i].Confirmation != nil)
+    }
+    fmt.Printf("Restored state matches: %t (last irreversible block %d)\n", matches, restored.LastIrreversible)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+)
+
+var errBadVote = errors.New("invalid vote")
+
+// Unbonding 撤回质押后处于解绑期的代币，在 ReleaseIndex 高度的区块回到可用余额
+type Unbonding struct {
+    Address      string
+    Amount       int
+    ReleaseIndex int
+}
+
+// applyVote 追加质押 Amount 个代币，并用 Candidates 替换原有的赞成名单；Amount 为0即为改投
+func (s *State) applyVote(tx Transaction) error {
+    if tx.Amount < 0 {
+        return errBadAmount
+    }
+    if len(tx.Candidates) == 0 || len(tx.Candidates) > maxVotesPerVoter {
+        return fmt.Errorf("%w: must approve 1 to %d candidates", errBadVote, maxVotesPerVoter)
+    }
+    seen := make(map[string]bool, len(tx.Candidates))
+    for _, candidate := range tx.Candidates {
+        if !s.Candidates[candidate] {
+            return fmt.Errorf("%w: %s is not a candidate", errBadVote, candidate)
+        }
+        if seen[candidate] {
+            return fmt.Errorf("%w: duplicate candidate %s", errBadVote, candidate)
+        }
+        seen[candidate] = true
+    }
+    if s.Staked[tx.From]+tx.Amount == 0 {
+        return fmt.Errorf("%w: nothing staked", errBadVote)
+    }
+    if s.Balances[tx.From] < tx.Amount {
+        return fmt.Errorf("%w: %s has %d, needs %d", errOverdraft, tx.From, s.Balances[tx.From], tx.Amount)
+    }
+    s.Balances[tx.From] -= tx.Amount
+    s.Staked[tx.From] += tx.Amount
+    s.Approvals[tx.From] = append([]string(nil), tx.Candidates...)
+    return nil
+}
+
+// applyUnvote 撤回 Amount 个质押代币进入一届长的解绑期，全部撤回时同时清空赞成名单
+func (s *State) applyUnvote(tx Transaction) error {
+    if tx.Amount <= 0 {
+        return errBadAmount
+    }
+    if s.Staked[tx.From] < tx.Amount {
+        return fmt.Errorf("%w: %s has %d staked, unstaking %d", errOverdraft, tx.From, s.Staked[tx.From], tx.Amount)
+    }
+    s.Staked[tx.From] -= tx.Amount
+    if s.Staked[tx.From] == 0 {
+        delete(s.Staked, tx.From)
+        delete(s.Approvals, tx.From)
+    }
+    s.Unbonding = append(s.Unbonding, Unbonding{
+        Address:      tx.From,
+        Amount:       tx.Amount,
+        ReleaseIndex: s.Height + s.Params.epochLength(),
+    })
+    return nil
+}
+
+// releaseUnbonding 将解绑期已满的代币退回可用余额
+func (s *State) releaseUnbonding() {
+    pending := s.Unbonding[:0]
+    for _, u := range s.Unbonding {
+        if u.ReleaseIndex <= s.Height {
+            s.Balances[u.Address] += u.Amount
+            continue
+        }
+        pending = append(pending, u)
+    }
+    s.Unbonding = pending
+}
+
+// randomApprovals 随机选择1到 maxVotesPerVoter 个候选人
+func randomApprovals(candidates []string) []string {
+    count := min(1+rng.Intn(maxVotesPerVoter), len(candidates))
+    approvals := make([]string, 0, count)
+    for _, i := range rng.Perm(len(candidates))[:count] {
+        approvals = append(approvals, candidates[i])
+    }
+    return approvals
+}
+
+// simulateVoteChanges 随机让若干持币人按各自的投票模型追加质押并改投、或撤回部分质押；卡特尔成员只会把一半余额追加质押投给卡特尔
+func (bc *Blockchain) simulateVoteChanges(count int) {
+    for i := 0; i < count; i++ {
+        node := &bc.Nodes[rng.Intn(len(bc.Nodes))]
+        staked := bc.State.Staked[node.Address]
+        if node.model == modelCartel {
+            if amount := bc.State.Balances[node.Address] / 2; amount > 0 {
+                bc.signAndSubmit(node, Transaction{Type: TxVote, Amount: amount, Candidates: bc.cartel.Members})
+            }
+            continue
+        }
+        if staked > 0 && rng.Intn(3) == 0 {
+            bc.signAndSubmit(node, Transaction{Type: TxUnvote, Amount: 1 + rng.Intn(staked)})
+            continue
+        }
+        amount := rng.Intn(bc.State.Balances[node.Address]/2 + 1)
+        if staked+amount == 0 {
+            continue
+        }
+        bc.signAndSubmit(node, Transaction{Type: TxVote, Amount: amount, Candidates: bc.approvalsFor(node)})
+    }
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 合并挖矿：辅助链的区块哈希被承诺在父链区块数据中，
+// 父链矿工算出的哈希只要满足辅助链自己的目标值，就可以作为辅助链区块的工作量证明
+
+// auxCommitmentPrefix 父链区块数据中辅助链承诺的前缀
+var auxCommitmentPrefix = []byte("auxpow:")
+
+// AuxBlock 辅助链区块，自身不带nonce，工作量来自父链
+type AuxBlock struct {
+	Height           int     `json:"height"`
+	Timestamp        int64   `json:"timestamp"`
+	Data             string  `json:"data"`
+	PrevBlockHashHex string  `json:"prevBlockHashHex"`
+	TargetBit        float64 `json:"targetBit"`
+	HashHex          string  `json:"hashHex"`
+	AuxPow           *AuxPow `json:"auxPow,omitempty"`
+}
+
+// AuxPow 辅助工作量证明：父链区块头、父链Proof以及承诺在父链数据中的位置
+type AuxPow struct {
+	ParentCoinBase         int64   `json:"parentCoinBase"`
+	ParentTimestamp        int64   `json:"parentTimestamp"`
+	ParentDataHex          string  `json:"parentDataHex"`
+	ParentPrevBlockHashHex string  `json:"parentPrevBlockHashHex"`
+	ParentTargetBit        float64 `json:"parentTargetBit"`
+	ParentProof            Proof   `json:"parentProof"`
+	CommitmentOffset       int     `json:"commitmentOffset"`
+}
+
+// AuxChain 辅助链
+type AuxChain struct {
+	targetBit float64
+	blocks    []AuxBlock
+	mutex     sync.RWMutex
+}
+
+// auxWork 父链矿工正在为辅助链顺带计算的工作
+type auxWork struct {
+	chain  *AuxChain
+	block  AuxBlock
+	offset int
+	target *big.Int
+}
+
+// NewAuxChain 新建辅助链
+func NewAuxChain(targetBit float64) *AuxChain {
+	genesis := AuxBlock{Data: "辅助链创世区块"}
+	genesis.HashHex = hex.EncodeToString(genesis.calculateHash())
+	return &AuxChain{
+		targetBit: targetBit,
+		blocks:    []AuxBlock{genesis},
+	}
+}
+
+// calculateHash 计算辅助链区块头哈希
+func (ab *AuxBlock) calculateHash() []byte {
+	hash := sha256.Sum256(bytes.Join(
+		[][]byte{
+			int2Hex(int64(ab.Height)),
+			[]byte(ab.PrevBlockHashHex),
+			[]byte(ab.Data),
+			int2Hex(ab.Timestamp),
+			int2Hex(int64(ab.TargetBit)),
+		},
+		[]byte{},
+	))
+	return hash[:]
+}
+
+// auxCommitment 辅助链区块哈希在父链数据中的承诺
+func auxCommitment(hashHex string) []byte {
+	return append(append([]byte{}, auxCommitmentPrefix...), hashHex...)
+}
+
+// newAuxWork 组装下一个辅助链区块，offset 为承诺在父链数据中的位置
+func (ac *AuxChain) newAuxWork(data string, offset int) *auxWork {
+	ac.mutex.RLock()
+	defer ac.mutex.RUnlock()
+	tip := ac.blocks[len(ac.blocks)-1]
+	block := AuxBlock{
+		Height:           tip.Height + 1,
+		Timestamp:        time.Now().Unix(),
+		Data:             data,
+		PrevBlockHashHex: tip.HashHex,
+		TargetBit:        ac.targetBit,
+	}
+	block.HashHex = hex.EncodeToString(block.calculateHash())
+	target := big.NewInt(1)
+	target.Lsh(target, uint(256-ac.targetBit))
+	return &auxWork{chain: ac, block: block, offset: offset, target: target}
+}
+
+// submit 用父链区块头和满足辅助链目标的nonce提交辅助链区块
+func (w *auxWork) submit(parent *BlockWithoutProof, nonce int64, hash []byte) {
+	block := w.block
+	block.AuxPow = &AuxPow{
+		ParentCoinBase:         parent.CoinBase,
+		ParentTimestamp:        parent.timestamp,
+		ParentDataHex:          hex.EncodeToString(parent.data),
+		ParentPrevBlockHashHex: parent.PrevBlockHashHex,
+		ParentTargetBit:        parent.TargetBit,
+		ParentProof: Proof{
+			Nonce:   nonce,
+			hash:    hash,
+			HashHex: hex.EncodeToString(hash),
+		},
+		CommitmentOffset: w.offset,
+	}
+	if err := w.chain.SubmitBlock(block); err == nil {
+		fmt.Printf(" %s: %d 节点通过合并挖矿挖出了一个辅助链区块 %s\n", time.Now(), parent.CoinBase, block.HashHex)
+	}
+}
+
+// SubmitBlock 校验辅助工作量证明并将区块接入辅助链
+func (ac *AuxChain) SubmitBlock(block AuxBlock) error {
+	ac.mutex.Lock()
+	defer ac.mutex.Unlock()
+	tip := ac.blocks[len(ac.blocks)-1]
+	if block.Height != tip.Height+1 || block.PrevBlockHashHex != tip.HashHex {
+		return fmt.Errorf("辅助链区块 %d 没有接在链尾", block.Height)
+	}
+	if block.TargetBit != ac.targetBit {
+		return fmt.Errorf("辅助链区块 %d 难度不符", block.Height)
+	}
+	if err := block.Verify(); err != nil {
+		return err
+	}
+	ac.blocks = append(ac.blocks, block)
+	return nil
+}
+
+// Verify 校验辅助链区块：父链区块头哈希正确、承诺了本区块哈希且满足辅助链目标
+func (ab *AuxBlock) Verify() error {
+	if hex.EncodeToString(ab.calculateHash()) != ab.HashHex {
+		return fmt.Errorf("辅助链区块 %d 哈希不符", ab.Height)
+	}
+	pow := ab.AuxPow
+	if pow == nil {
+		return fmt.Errorf("辅助链区块 %d 缺少辅助工作量证明", ab.Height)
+	}
+	data, err := hex.DecodeString(pow.ParentDataHex)
+	if err != nil {
+		return err
+	}
+	prevHash, err := hex.DecodeString(pow.ParentPrevBlockHashHex)
+	if err != nil {
+		return err
+	}
+	parent := BlockWithoutProof{
+		CoinBase:      pow.ParentCoinBase,
+		timestamp:     pow.ParentTimestamp,
+		data:          data,
+		prevBlockHash: prevHash,
+		TargetBit:     pow.ParentTargetBit,
+	}
+	hash := sha256.Sum256(parent.prepareData(pow.ParentProof.Nonce))
+	if hex.EncodeToString(hash[:]) != pow.ParentProof.HashHex {
+		return fmt.Errorf("辅助链区块 %d 的父链区块头哈希不符", ab.Height)
+	}
+	commitment := auxCommitment(ab.HashHex)
+	if pow.CommitmentOffset < 0 || pow.CommitmentOffset+len(commitment) > len(data) ||
+		!bytes.Equal(data[pow.CommitmentOffset:pow.CommitmentOffset+len(commitment)], commitment) {
+		return fmt.Errorf("辅助链区块 %d 的承诺证明无效", ab.Height)
+	}
+	target := big.NewInt(1)
+	target.Lsh(target, uint(256-ab.TargetBit))
+	var hashInt big.Int
+	hashInt.SetBytes(hash[:])
+	if hashInt.Cmp(target) >= 0 {
+		return fmt.Errorf("辅助链区块 %d 的父链哈希未达到辅助链目标", ab.Height)
+	}
+	return nil
+}
+
+// Blocks 获取辅助链区块
+func (ac *AuxChain) Blocks() []AuxBlock {
+	ac.mutex.RLock()
+	defer ac.mutex.RUnlock()
+	blocks := make([]AuxBlock, len(ac.blocks))
+	copy(blocks, ac.blocks)
+	return blocks
+}
+
+// getAuxChainInfo 获取辅助链信息
+func getAuxChainInfo(blockchain *Blockchain) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if blockchain.aux == nil {
+			c.JSON(404, gin.H{
+				"message": "未开启合并挖矿",
+			})
+			return
+		}
+		c.JSON(200, gin.H{
+			"targetBit": blockchain.aux.targetBit,
+			"blocks":    blockchain.aux.Blocks(),
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 区块树的Graphviz/DOT导出，用于观察分叉结构
+
+// minerColors 按矿工编号循环使用的节点颜色
+var minerColors = []string{
+	"#8dd3c7", "#ffffb3", "#bebada", "#fb8072", "#80b1d3", "#fdb462",
+	"#b3de69", "#fccde5", "#d9d9d9", "#bc80bd", "#ccebc5", "#ffed6f",
+}
+
+// maxStaleBlocks 最多保留的孤块数，超出时丢弃最早记录的
+const maxStaleBlocks = 256
+
+// recordStaleBlock 记录未能接入主链但工作量证明有效的区块，调用方需持有写锁
+func (bc *Blockchain) recordStaleBlock(block *Block) {
+	if !block.Verify() {
+		return
+	}
+	for _, b := range bc.blocks {
+		if b.HashHex == block.HashHex {
+			return
+		}
+	}
+	for _, b := range bc.staleBlocks {
+		if b.HashHex == block.HashHex {
+			return
+		}
+	}
+	if len(bc.staleBlocks) >= maxStaleBlocks {
+		bc.staleBlocks = append(bc.staleBlocks[:0], bc.staleBlocks[len(bc.staleBlocks)-maxStaleBlocks+1:]...)
+	}
+	bc.staleBlocks = append(bc.staleBlocks, *block)
+}
+
+// WriteBlockTreeDot 以DOT格式写出主链与孤块组成的区块树
+func (bc *Blockchain) WriteBlockTreeDot(w io.Writer) error {
+	bc.mutex.RLock()
+	defer bc.mutex.RUnlock()
+
+	heights := make(map[string]int, len(bc.blocks)+len(bc.staleBlocks))
+	var buf bytes.Buffer
+	buf.WriteString("digraph blocktree {\n")
+	buf.WriteString("\trankdir=LR;\n")
+	buf.WriteString("\tnode [shape=box, style=filled, fontname=\"monospace\"];\n")
+	for i, block := range bc.blocks {
+		heights[block.HashHex] = i
+		writeDotNode(&buf, block, i, false)
+	}
+	for _, block := range bc.staleBlocks {
+		parentHeight, ok := heights[block.PrevBlockHashHex]
+		if !ok {
+			continue
+		}
+		heights[block.HashHex] = parentHeight + 1
+		writeDotNode(&buf, block, parentHeight+1, true)
+	}
+	for i, block := range bc.blocks {
+		if i > 0 {
+			fmt.Fprintf(&buf, "\t%q -> %q;\n", dotNodeId(block.PrevBlockHashHex), dotNodeId(block.HashHex))
+		}
+	}
+	for _, block := range bc.staleBlocks {
+		if _, ok := heights[block.PrevBlockHashHex]; ok {
+			fmt.Fprintf(&buf, "\t%q -> %q [style=dashed];\n", dotNodeId(block.PrevBlockHashHex), dotNodeId(block.HashHex))
+		}
+	}
+	buf.WriteString("}\n")
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// writeDotNode 写出一个区块节点，孤块使用虚线边框
+func writeDotNode(buf *bytes.Buffer, block Block, height int, stale bool) {
+	if height == 0 {
+		fmt.Fprintf(buf, "\t%q [label=\"genesis\\n%s\", fillcolor=\"#ffffff\"];\n",
+			dotNodeId(block.HashHex), time.Unix(block.ActualTimestamp, 0).Format(time.DateTime))
+		return
+	}
+	style := "filled,bold"
+	if stale {
+		style = "filled,dashed"
+	}
+	fmt.Fprintf(buf, "\t%q [label=\"#%d miner %d\\ndiff %.2f\\n%s\\n%s\", fillcolor=%q, style=%q];\n",
+		dotNodeId(block.HashHex), height, block.CoinBase, block.TargetBit,
+		time.Unix(block.ActualTimestamp, 0).Format(time.DateTime), block.HashHex[:12],
+		minerColors[int(block.CoinBase)%len(minerColors)], style)
+}
+
+// dotNodeId 区块在DOT图中的节点名，创世区块没有哈希
+func dotNodeId(hashHex string) string {
+	if hashHex == "" {
+		return "genesis"
+	}
+	return hashHex
+}
+
+// getBlockTreeDot 获取DOT格式的区块树
+func getBlockTreeDot(blockchain *Blockchain) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var buf bytes.Buffer
+		if err := blockchain.WriteBlockTreeDot(&buf); err != nil {
+			c.JSON(500, gin.H{
+				"message": err.Error(),
+			})
+			return
+		}
+		c.Data(200, "text/vnd.graphviz", buf.Bytes())
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// AppConfig 程序运行配置，可由配置文件和命令行参数共同给出
+type AppConfig struct {
+	Blockchain BlockchainConfig `json:"blockchain" yaml:"blockchain" toml:"blockchain"`
+	Listen     string           `json:"listen" yaml:"listen" toml:"listen"`
+	RunBlocks  int              `json:"runBlocks" yaml:"runBlocks" toml:"runBlocks"`
+}
+
+// defaultAppConfig 默认配置
+func defaultAppConfig() AppConfig {
+	return AppConfig{
+		Blockchain: BlockchainConfig{
+			MinerCount:                  4,
+			OutBlockTime:                10,
+			InitialDifficulty:           20,
+			ModifyDifficultyBlockNumber: 10,
+			BookkeepingIncentives:       20,
+			CoinbaseMaturity:            10,
+		},
+		Listen: ":8080",
+	}
+}
+
+// loadConfigFile 按扩展名解析YAML或TOML配置文件
+func loadConfigFile(path string, config *AppConfig) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml":
+		return yaml.Unmarshal(data, config)
+	case ".toml":
+		return toml.Unmarshal(data, config)
+	default:
+		return fmt.Errorf("不支持的配置文件格式: %s", path)
+	}
+}
+
+// parseConfig 依次应用默认值、配置文件和显式给出的命令行参数
+func parseConfig(fs *flag.FlagSet, args []string) (AppConfig, error) {
+	config := defaultAppConfig()
+	defaults := config
+
+	configPath := fs.String("config", "", "YAML或TOML配置文件路径")
+	minerCount := fs.Int("miners", defaults.Blockchain.MinerCount, "初始矿工数量")
+	outBlockTime := fs.Uint("out-block-time", defaults.Blockchain.OutBlockTime, "期望出块时间（秒）")
+	initialDifficulty := fs.Float64("initial-difficulty", defaults.Blockchain.InitialDifficulty, "初始难度（前导零比特数）")
+	modifyDifficulty := fs.Uint("modify-difficulty-blocks", defaults.Blockchain.ModifyDifficultyBlockNumber, "每隔多少个区块调整一次难度")
+	incentives := fs.Uint("incentives", defaults.Blockchain.BookkeepingIncentives, "每个区块的记账奖励")
+	maturity := fs.Uint("coinbase-maturity", defaults.Blockchain.CoinbaseMaturity, "出块奖励需要再经过多少个区块才可花费")
+	auxTargetBit := fs.Float64("aux-target-bit", defaults.Blockchain.AuxTargetBit, "辅助链难度（前导零比特数），大于0时开启合并挖矿")
+	listen := fs.String("listen", defaults.Listen, "HTTP监听地址")
+	runBlocks := fs.Int("blocks", defaults.RunBlocks, "挖出指定数量的区块后退出，0表示一直运行")
+	if err := fs.Parse(args); err != nil {
+		return config, err
+	}
+
+	if *configPath != "" {
+		if err := loadConfigFile(*configPath, &config); err != nil {
+			return config, err
+		}
+	}
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "miners":
+			config.Blockchain.MinerCount = *minerCount
+		case "out-block-time":
+			config.Blockchain.OutBlockTime = *outBlockTime
+		case "initial-difficulty":
+			config.Blockchain.InitialDifficulty = *initialDifficulty
+		case "modify-difficulty-blocks":
+			config.Blockchain.ModifyDifficultyBlockNumber = *modifyDifficulty
+		case "incentives":
+			config.Blockchain.BookkeepingIncentives = *incentives
+		case "coinbase-maturity":
+			config.Blockchain.CoinbaseMaturity = *maturity
+		case "aux-target-bit":
+			config.Blockchain.AuxTargetBit = *auxTargetBit
+		case "listen":
+			config.Listen = *listen
+		case "blocks":
+			config.RunBlocks = *runBlocks
+		}
+	})
+	return config, config.validate()
+}
+
+// validate 检查配置是否合法
+func (config AppConfig) validate() error {
+	if config.Blockchain.MinerCount < 0 {
+		return fmt.Errorf("矿工数量不能为负数")
+	}
+	if config.Blockchain.ModifyDifficultyBlockNumber == 0 {
+		return fmt.Errorf("难度调整周期不能为0")
+	}
+	if config.Blockchain.InitialDifficulty <= 0 || config.Blockchain.InitialDifficulty >= 256 {
+		return fmt.Errorf("初始难度必须在 (0, 256) 之间")
+	}
+	if config.Blockchain.AuxTargetBit < 0 || config.Blockchain.AuxTargetBit >= 256 {
+		return fmt.Errorf("辅助链难度必须在 [0, 256) 之间")
+	}
+	if config.Blockchain.OutBlockTime == 0 {
+		return fmt.Errorf("期望出块时间不能为0")
+	}
+	if config.RunBlocks < 0 {
+		return fmt.Errorf("运行区块数不能为负数")
+	}
+	if config.RunBlocks > 0 && config.Blockchain.MinerCount == 0 {
+		return fmt.Errorf("没有矿工时无法挖出指定数量的区块")
+	}
+	return nil
+}
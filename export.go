@@ -0,0 +1,352 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 链数据导出与导入，供离线分析使用
+
+// ChainExport 区块链导出数据
+type ChainExport struct {
+	Config            BlockchainConfig   `json:"config"`
+	CurrentDifficulty float64            `json:"currentDifficulty"`
+	Blocks            []ExportedBlock    `json:"blocks"`
+	Miners            []Miner            `json:"miners"`
+	DifficultyChanges []DifficultyChange `json:"difficultyChanges"`
+	Transfers         []Transfer         `json:"transfers"`
+	AuxBlocks         []AuxBlock         `json:"auxBlocks,omitempty"`
+	StaleBlocks       []ExportedBlock    `json:"staleBlocks,omitempty"`
+}
+
+// ExportedBlock 导出的区块头信息
+type ExportedBlock struct {
+	Height           int     `json:"height"`
+	CoinBase         int64   `json:"coinBase"`
+	Timestamp        int64   `json:"timestamp"`
+	ActualTimestamp  int64   `json:"actualTimestamp"`
+	Interval         int64   `json:"interval"`
+	TargetBit        float64 `json:"targetBit"`
+	Nonce            int64   `json:"nonce"`
+	PrevBlockHashHex string  `json:"prevBlockHashHex"`
+	HashHex          string  `json:"hashHex"`
+	DataHex          string  `json:"dataHex"`
+}
+
+// Export 导出区块链的当前状态
+func (bc *Blockchain) Export() ChainExport {
+	bc.mutex.RLock()
+	defer bc.mutex.RUnlock()
+	exp := ChainExport{
+		Config:            bc.config,
+		CurrentDifficulty: bc.currentDifficulty,
+		Blocks:            make([]ExportedBlock, len(bc.blocks)),
+		Miners:            make([]Miner, len(bc.miners)),
+		DifficultyChanges: make([]DifficultyChange, len(bc.difficultyChanges)),
+		Transfers:         make([]Transfer, len(bc.transfers)),
+	}
+	parents := make(map[string]ExportedBlock, len(bc.blocks)+len(bc.staleBlocks))
+	for i, block := range bc.blocks {
+		var interval int64
+		if i > 0 {
+			interval = block.ActualTimestamp - bc.blocks[i-1].ActualTimestamp
+		}
+		exp.Blocks[i] = exportBlock(block, i, interval)
+		parents[block.HashHex] = exp.Blocks[i]
+	}
+	// 孤块的高度和出块间隔按其父区块计算，父区块未知时高度记为-1
+	for _, block := range bc.staleBlocks {
+		exported := exportBlock(block, -1, 0)
+		if parent, ok := parents[block.PrevBlockHashHex]; ok && parent.Height >= 0 {
+			exported.Height = parent.Height + 1
+			exported.Interval = block.ActualTimestamp - parent.ActualTimestamp
+		}
+		parents[block.HashHex] = exported
+		exp.StaleBlocks = append(exp.StaleBlocks, exported)
+	}
+	copy(exp.Miners, bc.miners)
+	copy(exp.DifficultyChanges, bc.difficultyChanges)
+	copy(exp.Transfers, bc.transfers)
+	if bc.aux != nil {
+		exp.AuxBlocks = bc.aux.Blocks()
+	}
+	return exp
+}
+
+// exportBlock 导出区块头信息
+func exportBlock(block Block, height int, interval int64) ExportedBlock {
+	return ExportedBlock{
+		Height:           height,
+		CoinBase:         block.CoinBase,
+		Timestamp:        block.timestamp,
+		ActualTimestamp:  block.ActualTimestamp,
+		Interval:         interval,
+		TargetBit:        block.TargetBit,
+		Nonce:            block.Nonce,
+		PrevBlockHashHex: block.PrevBlockHashHex,
+		HashHex:          block.HashHex,
+		DataHex:          hex.EncodeToString(block.data),
+	}
+}
+
+// WriteJSON 以JSON格式写出导出数据
+func (exp ChainExport) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(exp)
+}
+
+// WriteCSV 以CSV格式写出导出数据，table 可选 blocks、miners、difficulty、transfers
+func (exp ChainExport) WriteCSV(w io.Writer, table string) error {
+	var records [][]string
+	switch table {
+	case "", "blocks":
+		records = append(records, []string{"height", "coinBase", "timestamp", "actualTimestamp", "interval",
+			"targetBit", "nonce", "prevBlockHashHex", "hashHex", "dataHex"})
+		for _, b := range exp.Blocks {
+			records = append(records, []string{
+				strconv.Itoa(b.Height),
+				strconv.FormatInt(b.CoinBase, 10),
+				strconv.FormatInt(b.Timestamp, 10),
+				strconv.FormatInt(b.ActualTimestamp, 10),
+				strconv.FormatInt(b.Interval, 10),
+				strconv.FormatFloat(b.TargetBit, 'g', -1, 64),
+				strconv.FormatInt(b.Nonce, 10),
+				b.PrevBlockHashHex,
+				b.HashHex,
+				b.DataHex,
+			})
+		}
+	case "miners":
+		records = append(records, []string{"id", "balance", "immatureBalance"})
+		for _, m := range exp.Miners {
+			records = append(records, []string{
+				strconv.FormatInt(m.Id, 10),
+				strconv.FormatUint(uint64(m.Balance), 10),
+				strconv.FormatUint(uint64(m.Immature), 10),
+			})
+		}
+	case "difficulty":
+		records = append(records, []string{"height", "preDifficulty", "nowDifficulty"})
+		for _, d := range exp.DifficultyChanges {
+			records = append(records, []string{
+				strconv.Itoa(d.Height),
+				strconv.FormatFloat(d.PreDifficulty, 'g', -1, 64),
+				strconv.FormatFloat(d.NowDifficulty, 'g', -1, 64),
+			})
+		}
+	case "transfers":
+		records = append(records, []string{"height", "from", "to", "amount"})
+		for _, t := range exp.Transfers {
+			records = append(records, []string{
+				strconv.Itoa(t.Height),
+				strconv.FormatInt(t.From, 10),
+				strconv.FormatInt(t.To, 10),
+				strconv.FormatUint(uint64(t.Amount), 10),
+			})
+		}
+	default:
+		return fmt.Errorf("未知的导出表: %s", table)
+	}
+	writer := csv.NewWriter(w)
+	if err := writer.WriteAll(records); err != nil {
+		return err
+	}
+	return writer.Error()
+}
+
+// WriteFile 将导出数据写入文件，format 可选 json、csv
+func (exp ChainExport) WriteFile(path, format, table string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	switch format {
+	case "", "json":
+		err = exp.WriteJSON(file)
+	case "csv":
+		err = exp.WriteCSV(file, table)
+	default:
+		err = fmt.Errorf("未知的导出格式: %s", format)
+	}
+	if err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// LoadExport 从文件读取JSON格式的导出数据
+func LoadExport(path string) (ChainExport, error) {
+	var exp ChainExport
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return exp, err
+	}
+	err = json.Unmarshal(data, &exp)
+	return exp, err
+}
+
+// ImportChain 根据导出数据重建区块链，逐块重新校验工作量证明、难度、奖励和转账
+func ImportChain(exp ChainExport) (*Blockchain, error) {
+	if len(exp.Blocks) == 0 {
+		return nil, fmt.Errorf("导出数据中没有创世区块")
+	}
+	if exp.Config.ModifyDifficultyBlockNumber == 0 {
+		return nil, fmt.Errorf("难度调整周期不能为0")
+	}
+	config := exp.Config
+	config.MinerCount = len(exp.Miners)
+	bc := NewBlockChainNetWork(config)
+
+	genesisData, err := hex.DecodeString(exp.Blocks[0].DataHex)
+	if err != nil {
+		return nil, fmt.Errorf("创世区块数据解码失败: %v", err)
+	}
+	genesis := GenerateGenesisBlock(genesisData)
+	genesis.ActualTimestamp = exp.Blocks[0].ActualTimestamp
+	bc.blocks[0] = *genesis
+
+	transfers := exp.Transfers
+	applyTransfers := func(height int) error {
+		for len(transfers) > 0 && transfers[0].Height == height {
+			if err := bc.applyTransfer(transfers[0]); err != nil {
+				return fmt.Errorf("高度 %d 的转账无效: %v", height, err)
+			}
+			bc.transfers = append(bc.transfers, transfers[0])
+			transfers = transfers[1:]
+		}
+		return nil
+	}
+	if err := applyTransfers(0); err != nil {
+		return nil, err
+	}
+	for i := 1; i < len(exp.Blocks); i++ {
+		block, err := exp.Blocks[i].toBlock()
+		if err != nil {
+			return nil, fmt.Errorf("区块 %d 解码失败: %v", i, err)
+		}
+		if exp.Blocks[i].Height != i {
+			return nil, fmt.Errorf("区块 %d 高度不连续", i)
+		}
+		if block.CoinBase < 0 || block.CoinBase >= int64(len(bc.miners)) {
+			return nil, fmt.Errorf("区块 %d 的矿工 %d 不存在", i, block.CoinBase)
+		}
+		if !bc.acceptBlock(block) {
+			return nil, fmt.Errorf("区块 %d 校验失败", i)
+		}
+		if err := applyTransfers(i); err != nil {
+			return nil, err
+		}
+	}
+	if len(transfers) > 0 {
+		return nil, fmt.Errorf("转账记录的高度 %d 超出链高度", transfers[0].Height)
+	}
+	if len(exp.AuxBlocks) > 0 && bc.aux == nil {
+		return nil, fmt.Errorf("导出数据包含辅助链区块，但未配置辅助链难度")
+	}
+	for i := 1; i < len(exp.AuxBlocks); i++ {
+		if err := bc.aux.SubmitBlock(exp.AuxBlocks[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	for i, eb := range exp.StaleBlocks {
+		block, err := eb.toBlock()
+		if err != nil {
+			return nil, fmt.Errorf("孤块 %d 解码失败: %v", i, err)
+		}
+		if !block.Verify() {
+			return nil, fmt.Errorf("孤块 %s 的工作量证明无效", eb.HashHex)
+		}
+		bc.recordStaleBlock(block)
+	}
+
+	if bc.currentDifficulty != exp.CurrentDifficulty {
+		return nil, fmt.Errorf("当前难度不一致: 导出 %v, 重算 %v", exp.CurrentDifficulty, bc.currentDifficulty)
+	}
+	if len(bc.difficultyChanges) != len(exp.DifficultyChanges) {
+		return nil, fmt.Errorf("难度调整次数不一致: 导出 %d, 重算 %d", len(exp.DifficultyChanges), len(bc.difficultyChanges))
+	}
+	for i, change := range bc.difficultyChanges {
+		if change != exp.DifficultyChanges[i] {
+			return nil, fmt.Errorf("第 %d 次难度调整不一致", i)
+		}
+	}
+	for i, miner := range bc.miners {
+		if miner.Id != exp.Miners[i].Id || miner.Balance != exp.Miners[i].Balance || miner.Immature != exp.Miners[i].Immature {
+			return nil, fmt.Errorf("矿工 %d 余额不一致: 导出 %d/%d, 重算 %d/%d", i,
+				exp.Miners[i].Balance, exp.Miners[i].Immature, miner.Balance, miner.Immature)
+		}
+	}
+	return bc, nil
+}
+
+// toBlock 将导出的区块头还原为区块
+func (eb ExportedBlock) toBlock() (*Block, error) {
+	data, err := hex.DecodeString(eb.DataHex)
+	if err != nil {
+		return nil, err
+	}
+	prevHash, err := hex.DecodeString(eb.PrevBlockHashHex)
+	if err != nil {
+		return nil, err
+	}
+	hash, err := hex.DecodeString(eb.HashHex)
+	if err != nil {
+		return nil, err
+	}
+	return &Block{
+		BlockWithoutProof: &BlockWithoutProof{
+			CoinBase:         eb.CoinBase,
+			timestamp:        eb.Timestamp,
+			data:             data,
+			prevBlockHash:    prevHash,
+			PrevBlockHashHex: eb.PrevBlockHashHex,
+			TargetBit:        eb.TargetBit,
+		},
+		Proof: Proof{
+			ActualTimestamp: eb.ActualTimestamp,
+			Nonce:           eb.Nonce,
+			hash:            hash,
+			HashHex:         eb.HashHex,
+		},
+	}, nil
+}
+
+// exportChain 导出区块链数据
+func exportChain(blockchain *Blockchain) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		exp := blockchain.Export()
+		switch c.DefaultQuery("format", "json") {
+		case "json":
+			c.Header("Content-Type", "application/json")
+			if err := exp.WriteJSON(c.Writer); err != nil {
+				c.Error(err)
+			}
+		case "csv":
+			table := c.DefaultQuery("table", "blocks")
+			var buf bytes.Buffer
+			if err := exp.WriteCSV(&buf, table); err != nil {
+				c.JSON(400, gin.H{
+					"message": err.Error(),
+				})
+				return
+			}
+			c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s.csv", table))
+			c.Data(200, "text/csv", buf.Bytes())
+		default:
+			c.JSON(400, gin.H{
+				"message": "format 仅支持 json 或 csv",
+			})
+		}
+	}
+}
@@ -2,12 +2,19 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
+	"flag"
 	"fmt"
+	"log"
 	"math"
 	"math/big"
+	"net/http"
+	"os"
+	"os/signal"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -41,32 +48,52 @@ type BlockWithoutProof struct {
 	prevBlockHash      []byte
 	PrevBlockHashHex   string  `json:"prevBlockHashHex"`
 	TargetBit          float64 `json:"targetBit"`
+	auxWork            *auxWork
 }
 
 // Miner 矿工结构
 type Miner struct {
 	Id            int64          `json:"id"`
 	Balance       uint           `json:"balance"`
+	Immature      uint           `json:"immatureBalance"`
 	blockchain    *Blockchain
 	waitForSignal chan interface{} `json:"-"`
 }
 
 // Blockchain 区块链数据
 type Blockchain struct {
-	config           BlockchainConfig
+	config            BlockchainConfig
 	currentDifficulty float64
 	blocks            []Block
+	staleBlocks       []Block
 	miners            []Miner
+	difficultyChanges []DifficultyChange
+	transfers         []Transfer
+	aux               *AuxChain
+	stopHeight        int
+	stopped           chan struct{}
+	ctx               context.Context
+	cancel            context.CancelFunc
+	wg                sync.WaitGroup
 	mutex             *sync.RWMutex
 }
 
+// DifficultyChange 难度调整记录
+type DifficultyChange struct {
+	Height        int     `json:"height"`
+	PreDifficulty float64 `json:"preDifficulty"`
+	NowDifficulty float64 `json:"nowDifficulty"`
+}
+
 // BlockchainConfig 区块链配置信息
 type BlockchainConfig struct {
-	MinerCount                 int
-	OutBlockTime               uint
-	InitialDifficulty          float64
-	ModifyDifficultyBlockNumber uint
-	BookkeepingIncentives      uint
+	MinerCount                  int     `json:"minerCount" yaml:"minerCount" toml:"minerCount"`
+	OutBlockTime                uint    `json:"outBlockTime" yaml:"outBlockTime" toml:"outBlockTime"`
+	InitialDifficulty           float64 `json:"initialDifficulty" yaml:"initialDifficulty" toml:"initialDifficulty"`
+	ModifyDifficultyBlockNumber uint    `json:"modifyDifficultyBlockNumber" yaml:"modifyDifficultyBlockNumber" toml:"modifyDifficultyBlockNumber"`
+	BookkeepingIncentives       uint    `json:"bookkeepingIncentives" yaml:"bookkeepingIncentives" toml:"bookkeepingIncentives"`
+	CoinbaseMaturity            uint    `json:"coinbaseMaturity" yaml:"coinbaseMaturity" toml:"coinbaseMaturity"`
+	AuxTargetBit                float64 `json:"auxTargetBit" yaml:"auxTargetBit" toml:"auxTargetBit"`
 }
 
 // BlockchainInfo 区块链信息
@@ -76,20 +103,80 @@ type BlockchainInfo struct {
 }
 
 func main() {
-	var count int
-	fmt.Printf("请输入初始矿工数量：")
-	fmt.Scanf("%d", &count)
-	time.Sleep(10 * time.Second)
+	importPath := flag.String("import", "", "从JSON导出文件重建区块链（导入时会重新校验全部区块）")
+	exportPath := flag.String("export", "", "导出文件路径；未指定 -blocks 时导出后立即退出，否则在运行结束时导出")
+	exportFormat := flag.String("format", "json", "导出格式: json 或 csv")
+	exportTable := flag.String("table", "blocks", "CSV导出的数据表: blocks、miners 或 difficulty")
+	snapshotPath := flag.String("snapshot", "", "快照文件路径；启动时若存在则从中恢复，退出时写入最终快照")
+	config, err := parseConfig(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *importPath != "" && *snapshotPath != "" && fileExists(*snapshotPath) {
+		log.Fatal("-import 与已存在的 -snapshot 快照不能同时使用")
+	}
+	restorePath := *importPath
+	if *snapshotPath != "" && fileExists(*snapshotPath) {
+		restorePath = *snapshotPath
+	}
+
+	var work *Blockchain
+	if restorePath != "" {
+		exp, err := LoadExport(restorePath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		work, err = ImportChain(exp)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("已从 %s 导入 %d 个区块\n", restorePath, len(exp.Blocks))
+	} else {
+		work = NewBlockChainNetWork(config.Blockchain)
+	}
+	if *exportPath != "" && config.RunBlocks == 0 {
+		if err := work.Export().WriteFile(*exportPath, *exportFormat, *exportTable); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("已导出到 %s\n", *exportPath)
+		return
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	if config.RunBlocks > 0 {
+		done := work.StopAfter(config.RunBlocks)
+		go func() {
+			select {
+			case <-done:
+				fmt.Printf("已挖出 %d 个区块，准备退出\n", config.RunBlocks)
+				stop()
+			case <-ctx.Done():
+			}
+		}()
+	}
+
 	fmt.Printf("开始挖矿\n")
-	work := NewBlockChainNetWork(BlockchainConfig{
-		MinerCount:                 count,
-		OutBlockTime:               10,
-		InitialDifficulty:          20,
-		ModifyDifficultyBlockNumber: 10,
-		BookkeepingIncentives:      20,
-	})
 	work.RunBlockChainNetWork()
-	RunRouter(work)
+	if err := RunRouter(ctx, work, config.Listen); err != nil {
+		log.Println("web服务异常退出:", err)
+	}
+	work.Shutdown()
+	fmt.Printf("所有矿工已停止\n")
+
+	if *snapshotPath != "" {
+		if err := work.WriteSnapshot(*snapshotPath); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("已写入快照 %s\n", *snapshotPath)
+	}
+	if *exportPath != "" {
+		if err := work.Export().WriteFile(*exportPath, *exportFormat, *exportTable); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("已导出到 %s\n", *exportPath)
+	}
 }
 
 // NewBlockChainNetWork 新建一个区块链网络
@@ -99,6 +186,10 @@ func NewBlockChainNetWork(blockchainConfig BlockchainConfig) *Blockchain {
 		mutex:            &sync.RWMutex{},
 		currentDifficulty: blockchainConfig.InitialDifficulty,
 	}
+	b.ctx, b.cancel = context.WithCancel(context.Background())
+	if blockchainConfig.AuxTargetBit > 0 {
+		b.aux = NewAuxChain(blockchainConfig.AuxTargetBit)
+	}
 	b.blocks = append(b.blocks, *GenerateGenesisBlock([]byte("")))
 	for i := 0; i < blockchainConfig.MinerCount; i++ {
 		miner := Miner{
@@ -122,18 +213,35 @@ func GenerateGenesisBlock(data []byte) *Block {
 
 // RunBlockChainNetWork 运行区块链网络
 func (b *Blockchain) RunBlockChainNetWork() {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
 	for _, m := range b.miners {
-		go m.run()
+		b.startMiner(m)
 	}
 }
 
-// run 矿工挖矿逻辑
-func (m Miner) run() {
+// startMiner 启动矿工协程，调用方需持有锁
+func (b *Blockchain) startMiner(m Miner) {
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		m.run(b.ctx)
+	}()
+}
+
+// Shutdown 停止所有矿工并等待其退出
+func (b *Blockchain) Shutdown() {
+	b.cancel()
+	b.wg.Wait()
+}
+
+// run 矿工挖矿逻辑，ctx 取消后退出
+func (m Miner) run(ctx context.Context) {
 	count := 0
-	for {
+	for ctx.Err() == nil {
 		// 生成
 		blockWithoutProof := m.blockchain.assembleNewBlock(m.Id, []byte(fmt.Sprintf("模拟区块数据:%d:%d", m.Id, count)))
-		block, finish := blockWithoutProof.Mine(m.waitForSignal)
+		block, finish := blockWithoutProof.Mine(ctx.Done(), m.waitForSignal)
 		if !finish {
 			continue
 		} else {
@@ -155,11 +263,16 @@ func (b *Blockchain) assembleNewBlock(coinBase int64, data []byte) BlockWithoutP
 		TargetBit:        b.currentDifficulty,
 		PrevBlockHashHex: b.blocks[len(b.blocks)-1].HashHex,
 	}
+	if b.aux != nil {
+		// 在区块数据末尾承诺辅助链的候选区块
+		proof.auxWork = b.aux.newAuxWork(fmt.Sprintf("辅助链区块数据:%d:%s", coinBase, data), len(data))
+		proof.data = append(data, auxCommitment(proof.auxWork.block.HashHex)...)
+	}
 	return proof
 }
 
-// Mine 挖矿函数
-func (b *BlockWithoutProof) Mine(waitForSignal chan interface{}) (*Block, bool) {
+// Mine 挖矿函数，收到其他矿工的出块通知或停止信号时放弃当前区块
+func (b *BlockWithoutProof) Mine(stop <-chan struct{}, waitForSignal chan interface{}) (*Block, bool) {
 	target := big.NewInt(1)
 	target.Lsh(target, uint(256-b.TargetBit))
 
@@ -168,12 +281,18 @@ func (b *BlockWithoutProof) Mine(waitForSignal chan interface{}) (*Block, bool)
 	nonce := 0
 	for nonce != maxNonce {
 		select {
+		case <-stop:
+			return nil, false
 		case <-waitForSignal:
 			return nil, false
 		default:
 			data := b.prepareData(int64(nonce))
 			hash = sha256.Sum256(data)
 			hashInt.SetBytes(hash[:])
+			if b.auxWork != nil && hashInt.Cmp(b.auxWork.target) < 0 {
+				b.auxWork.submit(b, int64(nonce), hash[:])
+				b.auxWork = nil
+			}
 			if hashInt.Cmp(target) < 0 {
 				block := &Block{
 					BlockWithoutProof: b,
@@ -213,15 +332,39 @@ func (bc *Blockchain) AddBlock(block *Block, signal chan interface{}) {
 	bc.mutex.Lock()
 	defer bc.mutex.Unlock()
 	block.ActualTimestamp = time.Now().Unix()
-	if !bc.verifyNewBlock(block) {
+	if bc.stopped != nil && len(bc.blocks)-1 >= bc.stopHeight {
+		return
+	}
+	if !bc.acceptBlock(block) {
+		bc.recordStaleBlock(block)
 		return
 	}
+	bc.notifyMiners(block.CoinBase)
+	fmt.Printf(" %s: %d 节点挖出了一个新的区块 %s\n", time.Now(), block.CoinBase, block.HashHex)
+	if bc.stopped != nil && len(bc.blocks)-1 == bc.stopHeight {
+		close(bc.stopped)
+	}
+}
 
+// StopAfter 返回一个在再挖出 count 个区块后关闭的通道
+func (bc *Blockchain) StopAfter(count int) <-chan struct{} {
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+	bc.stopHeight = len(bc.blocks) - 1 + count
+	bc.stopped = make(chan struct{})
+	return bc.stopped
+}
+
+// acceptBlock 验证区块并将其接入链尾，同时调整难度、发放奖励
+func (bc *Blockchain) acceptBlock(block *Block) bool {
+	if !bc.verifyNewBlock(block) {
+		return false
+	}
 	bc.blocks = append(bc.blocks, *block)
 	bc.adjustDifficulty()
 	bc.bookkeepingRewards(block.CoinBase)
-	bc.notifyMiners(block.CoinBase)
-	fmt.Printf(" %s: %d 节点挖出了一个新的区块 %s\n", time.Now(), block.CoinBase, block.HashHex)
+	bc.matureRewards()
+	return true
 }
 
 // verifyNewBlock 验证新区块
@@ -239,7 +382,21 @@ func (bc *Blockchain) verifyNewBlock(block *Block) bool {
 	return true
 }
 
-
+// Verify 重新计算区块哈希并校验工作量证明
+func (block *Block) Verify() bool {
+	if block.BlockWithoutProof == nil {
+		return false
+	}
+	hash := sha256.Sum256(block.prepareData(block.Nonce))
+	if !bytes.Equal(hash[:], block.hash) || hex.EncodeToString(hash[:]) != block.HashHex {
+		return false
+	}
+	target := big.NewInt(1)
+	target.Lsh(target, uint(256-block.TargetBit))
+	var hashInt big.Int
+	hashInt.SetBytes(hash[:])
+	return hashInt.Cmp(target) < 0
+}
 
 // adjustDifficulty 根据挖矿的时间调整难度值
 func (bc *Blockchain) adjustDifficulty() {
@@ -255,13 +412,18 @@ func (bc *Blockchain) adjustDifficulty() {
 			ratio = 0.5
 		}
 		bc.currentDifficulty = bc.currentDifficulty * ratio
+		bc.difficultyChanges = append(bc.difficultyChanges, DifficultyChange{
+			Height:        len(bc.blocks) - 1,
+			PreDifficulty: preDiff,
+			NowDifficulty: bc.currentDifficulty,
+		})
 		fmt.Println("难度阈值改变 preDiff:", preDiff, "nowDiff", bc.currentDifficulty)
 	}
 }
 
-// bookkeepingRewards 给予挖矿成功的矿工奖励
+// bookkeepingRewards 给予挖矿成功的矿工奖励，奖励在成熟前不可花费
 func (bc *Blockchain) bookkeepingRewards(coinBase int64) {
-	bc.miners[coinBase].Balance += bc.config.BookkeepingIncentives
+	bc.miners[coinBase].Immature += bc.config.BookkeepingIncentives
 }
 
 // notifyMiners 通知所有矿工挖矿成功
@@ -275,18 +437,40 @@ func (bc *Blockchain) notifyMiners(sponsor int64) {
 	}
 }
 
-// RunRouter 运行web服务
-func RunRouter(blockchain *Blockchain) {
+// RunRouter 运行web服务，ctx 取消后优雅关闭
+func RunRouter(ctx context.Context, blockchain *Blockchain, addr string) error {
 	r := gin.Default()
 	r.GET("/addMiner", addMiner(blockchain))
 	r.GET("/getBlockChainInfo", getBlockChainInfo(blockchain))
-	r.Run()
+	r.GET("/export", exportChain(blockchain))
+	r.GET("/blocktree.dot", getBlockTreeDot(blockchain))
+	r.GET("/getBalance", getBalance(blockchain))
+	r.GET("/transfer", transfer(blockchain))
+	r.GET("/getAuxChainInfo", getAuxChainInfo(blockchain))
+	srv := &http.Server{Addr: addr, Handler: r}
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.ListenAndServe()
+	}()
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return srv.Shutdown(shutdownCtx)
 }
 
 // addMiner 增加矿工
 func addMiner(blockchain *Blockchain) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		blockchain.IncreaseMiner()
+		if !blockchain.IncreaseMiner() {
+			c.JSON(503, gin.H{
+				"message": "区块链网络已停止",
+			})
+			return
+		}
 		c.JSON(200, gin.H{
 			"message": "增加成功",
 		})
@@ -308,6 +492,9 @@ func getBlockChainInfo(blockchain *Blockchain) gin.HandlerFunc {
 func (bc *Blockchain) IncreaseMiner() bool {
 	bc.mutex.Lock()
 	defer bc.mutex.Unlock()
+	if bc.ctx.Err() != nil {
+		return false
+	}
 	var miner = Miner{
 		Id:            int64(len(bc.miners)),
 		Balance:       0,
@@ -315,7 +502,7 @@ func (bc *Blockchain) IncreaseMiner() bool {
 		waitForSignal: make(chan interface{}, 1),
 	}
 	bc.miners = append(bc.miners, miner)
-	go miner.run()
+	bc.startMiner(miner)
 	return true
 }
 
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 出块奖励的成熟期：奖励在其区块之上再出 CoinbaseMaturity 个区块后才可花费
+
+// Transfer 矿工之间的转账记录
+type Transfer struct {
+	Height int   `json:"height"`
+	From   int64 `json:"from"`
+	To     int64 `json:"to"`
+	Amount uint  `json:"amount"`
+}
+
+// matureRewards 将深度达到成熟期的区块奖励转为可花费余额，调用方需持有写锁
+func (bc *Blockchain) matureRewards() {
+	height := len(bc.blocks) - 1
+	matureHeight := height - int(bc.config.CoinbaseMaturity)
+	if matureHeight < 1 {
+		return
+	}
+	coinBase := bc.blocks[matureHeight].CoinBase
+	bc.miners[coinBase].Immature -= bc.config.BookkeepingIncentives
+	bc.miners[coinBase].Balance += bc.config.BookkeepingIncentives
+}
+
+// Transfer 从可花费余额中转账，未成熟的奖励不能花费
+func (bc *Blockchain) Transfer(from, to int64, amount uint) error {
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+	t := Transfer{Height: len(bc.blocks) - 1, From: from, To: to, Amount: amount}
+	if err := bc.applyTransfer(t); err != nil {
+		return err
+	}
+	bc.transfers = append(bc.transfers, t)
+	return nil
+}
+
+// applyTransfer 校验并执行一笔转账，调用方需持有写锁
+func (bc *Blockchain) applyTransfer(t Transfer) error {
+	if t.From < 0 || t.From >= int64(len(bc.miners)) {
+		return fmt.Errorf("矿工 %d 不存在", t.From)
+	}
+	if t.To < 0 || t.To >= int64(len(bc.miners)) {
+		return fmt.Errorf("矿工 %d 不存在", t.To)
+	}
+	if t.Amount == 0 {
+		return fmt.Errorf("转账金额必须大于0")
+	}
+	sender := &bc.miners[t.From]
+	if t.Amount > sender.Balance {
+		if t.Amount <= sender.Balance+sender.Immature {
+			return fmt.Errorf("矿工 %d 的可花费余额为 %d，其余 %d 为未成熟奖励", t.From, sender.Balance, sender.Immature)
+		}
+		return fmt.Errorf("矿工 %d 余额不足", t.From)
+	}
+	sender.Balance -= t.Amount
+	bc.miners[t.To].Balance += t.Amount
+	return nil
+}
+
+// getBalance 获取矿工的成熟与未成熟余额
+func getBalance(blockchain *Blockchain) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseInt(c.Query("miner"), 10, 64)
+		if err != nil {
+			c.JSON(400, gin.H{
+				"message": "miner 参数有误",
+			})
+			return
+		}
+		_, miners := blockchain.GetBlockInfo()
+		if id < 0 || id >= int64(len(miners)) {
+			c.JSON(404, gin.H{
+				"message": "矿工不存在",
+			})
+			return
+		}
+		c.JSON(200, gin.H{
+			"miner":           id,
+			"balance":         miners[id].Balance,
+			"immatureBalance": miners[id].Immature,
+		})
+	}
+}
+
+// transfer 矿工之间转账
+func transfer(blockchain *Blockchain) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		from, errFrom := strconv.ParseInt(c.Query("from"), 10, 64)
+		to, errTo := strconv.ParseInt(c.Query("to"), 10, 64)
+		amount, errAmount := strconv.ParseUint(c.Query("amount"), 10, 0)
+		if errFrom != nil || errTo != nil || errAmount != nil {
+			c.JSON(400, gin.H{
+				"message": "from、to、amount 参数有误",
+			})
+			return
+		}
+		if err := blockchain.Transfer(from, to, uint(amount)); err != nil {
+			c.JSON(400, gin.H{
+				"message": err.Error(),
+			})
+			return
+		}
+		c.JSON(200, gin.H{
+			"message": "转账成功",
+		})
+	}
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"os"
+)
+
+// WriteSnapshot 将链、矿工和难度状态写入快照文件，先写临时文件再原子替换
+func (bc *Blockchain) WriteSnapshot(path string) error {
+	tmp := path + ".tmp"
+	if err := bc.Export().WriteFile(tmp, "json", ""); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// fileExists 判断文件是否存在
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}